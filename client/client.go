@@ -1,6 +1,7 @@
 /*
 Key spaces:
 	/ping/<hostname> - All remote servers will update the ping keyspace every 15 seconds.
+	/facts/<hostname> - Facts published by remote servers for targeting, updated with the ping keyspace.
 	/state/<hostname> - Latest requested state for specified hostname.
 	/command/<hostname>/<type> - Requested action to be performed on the remote host.
 	/result/<id> - The key to save the result of a command in.
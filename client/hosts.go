@@ -1,6 +1,7 @@
 package clients
 
 import (
+	"encoding/json"
 	"fmt"
 	etcd "github.com/coreos/etcd/client"
 	"golang.org/x/net/context"
@@ -44,3 +45,34 @@ func (otter *Otter) UpdateHost(hostname, previous string) (string, error) {
 	}
 	return now, nil
 }
+
+/*
+Publish the facts of a host, facts expire with the host's ping
+*/
+func (otter *Otter) UpdateFacts(hostname string, facts map[string]string) error {
+	data, err := json.Marshal(facts)
+	if err != nil {
+		return err
+	}
+	_, err = otter.etcdKeysApi.Set(context.Background(), fmt.Sprintf("/facts/%s", hostname), string(data), &etcd.SetOptions{TTL: 60 * time.Second})
+	return err
+}
+
+/*
+Get the facts published by a host, a host which has not published facts has none
+*/
+func (otter *Otter) GetFacts(hostname string) (map[string]string, error) {
+	facts := make(map[string]string)
+	response, err := otter.etcdKeysApi.Get(context.Background(), fmt.Sprintf("/facts/%s", hostname), &etcd.GetOptions{})
+	if err != nil {
+		if strings.Contains(err.Error(), "Key not found") {
+			return facts, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal([]byte(response.Node.Value), &facts)
+	if err != nil {
+		return nil, err
+	}
+	return facts, nil
+}
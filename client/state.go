@@ -8,6 +8,18 @@ import (
 	"golang.org/x/net/context"
 )
 
+/*
+A StatePayloadError is returned when the states submitted to a host can not be loaded
+*/
+type StatePayloadError struct {
+	Key string // The etcd key the payload was read from
+	Err error
+}
+
+func (err *StatePayloadError) Error() string {
+	return fmt.Sprintf("Bad state payload at %s: %s", err.Key, err.Err)
+}
+
 func (otter *Otter) RetrieveStateMap() (*state.StateMap, error) {
 	key := fmt.Sprintf("/state/%s", otter.Hostname)
 	response, err := otter.etcdKeysApi.Get(context.Background(), key, &etcd.GetOptions{})
@@ -18,24 +30,39 @@ func (otter *Otter) RetrieveStateMap() (*state.StateMap, error) {
 		raw := response.Node.Value
 		stateMap, err := state.StateMapFromProcessedJson([]byte(raw))
 		if err != nil {
-			log.Fatalf("Bad JSON state payload: %s", raw)
+			log.Printf("Bad JSON state payload: %s", raw)
+			return nil, &StatePayloadError{Key: key, Err: err}
 		}
 		return stateMap, nil
 	}
 }
 
-func (otter *Otter) SubmitState(state string) error {
+/*
+Submit the states targeted to each registered host to the /state/<hostname> keyspace
+*/
+func (otter *Otter) SubmitState(stateMap *state.StateMap) error {
 	hosts, err := otter.ListHosts()
 	if err != nil {
 		return err
 	}
 	for _, host := range hosts {
-		_, err := otter.etcdKeysApi.Set(context.Background(), fmt.Sprintf("/state/%s", host), state, &etcd.SetOptions{})
+		facts, err := otter.GetFacts(host)
+		if err != nil {
+			return err
+		}
+		hostMap, err := stateMap.ForHost(host, facts)
 		if err != nil {
 			return err
 		}
-		log.Printf("Updated state for host: %s", host)
+		data, err := hostMap.ToJson()
+		if err != nil {
+			return err
+		}
+		_, err = otter.etcdKeysApi.Set(context.Background(), fmt.Sprintf("/state/%s", host), string(data), &etcd.SetOptions{})
+		if err != nil {
+			return err
+		}
+		log.Printf("Updated state for host %s with %d states", host, len(hostMap.States))
 	}
-
 	return nil
 }
@@ -3,7 +3,6 @@ package cmd
 import (
 	"github.com/spf13/cobra"
 	"github.com/vektorlab/otter/client"
-	"github.com/vektorlab/otter/state"
 )
 
 // applyCmd represents the apply command
@@ -12,11 +11,15 @@ var applyCmd = &cobra.Command{
 	Short: "Apply the loaded state to remote Otter daemons",
 	Long:  ``,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		stateMap, err := state.StateMapFromYamlPath(GetStatePath(cmd.Flag("state")))
+		stateMap, err := LoadStateMap(cmd.Flag("state"))
 		if err != nil {
 			return err
 		}
 		if cmd.Flag("local").Changed {
+			stateMap, err = LocalStateMap(stateMap)
+			if err != nil {
+				return err
+			}
 			DumpResults(stateMap.Apply())
 			return nil
 		} else {
@@ -24,11 +27,10 @@ var applyCmd = &cobra.Command{
 			if err != nil {
 				return err
 			}
-			data, err := stateMap.ToJson()
+			err = client.SubmitState(stateMap)
 			if err != nil {
 				return err
 			}
-			client.SubmitState(string(data))
 			resultMap, err := client.SubmitCommands("*", "apply")
 			if err != nil {
 				return err
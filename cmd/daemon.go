@@ -11,7 +11,11 @@ var daemonCmd = &cobra.Command{
 	Short: "Run the Otter client in daemon mode",
 	Long:  ``,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		daemon, err := daemon.NewDaemon(GetEtcdUrls(cmd.Flag("etcd")))
+		labels, err := ParseLabels(labelPairs)
+		if err != nil {
+			return err
+		}
+		daemon, err := daemon.NewDaemon(GetEtcdUrls(cmd.Flag("etcd")), labels)
 		if err != nil {
 			return err
 		}
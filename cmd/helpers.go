@@ -5,8 +5,10 @@ import (
 	"github.com/fatih/color"
 	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/pflag"
+	"github.com/vektorlab/otter/helpers"
 	"github.com/vektorlab/otter/state"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -28,26 +30,39 @@ func boolToColor(b bool) *color.Color {
 	}
 }
 
+func consistency(result *state.Result) string {
+	if result.Skipped {
+		return "skipped"
+	}
+	return strconv.FormatBool(result.Consistent)
+}
+
 func DumpResults(resultMap *state.ResultMap) {
 	table := tablewriter.NewWriter(os.Stdout)
 	tableData := make([][]string, len(resultMap.Results))
-	for host, results := range resultMap.Results {
-		for _, result := range results {
+	hosts := make([]string, 0)
+	for host := range resultMap.Results {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts) // Results of each host are kept in the order their states were declared
+	for _, host := range hosts {
+		for _, result := range resultMap.Results[host] {
 			c := boolToColor(result.Consistent).SprintfFunc()
 			tableData = append(tableData, []string{
 				c(host),
 				c(result.Metadata.Name),
 				c(result.Metadata.Type),
 				c(result.Metadata.State),
-				c(strconv.FormatBool(result.Consistent)),
+				c(consistency(result)),
 				fmt.Sprint(result.Message),
+				result.Metadata.Location(),
 			})
 		}
 	}
 	for _, v := range tableData {
 		table.Append(v)
 	}
-	table.SetHeader([]string{"Host", "Name", "Type", "State", "Consistent", "Result"})
+	table.SetHeader([]string{"Host", "Name", "Type", "State", "Consistent", "Result", "Source"})
 	table.Render()
 }
 
@@ -86,3 +101,62 @@ func GetStatePath(flag *pflag.Flag) string {
 		return flag.DefValue
 	}
 }
+
+/*
+Load the state configuration, variables from --var take precedence over the vars file which takes precedence over the
+vars: sections of the configuration
+*/
+func LoadStateMap(flag *pflag.Flag) (*state.StateMap, error) {
+	vars, err := StateVars()
+	if err != nil {
+		return nil, err
+	}
+	return state.StateMapFromYamlPathWithVars(GetStatePath(flag), vars)
+}
+
+/*
+Return the variables from the vars file and --var which override those declared in the state configuration
+*/
+func StateVars() (state.Vars, error) {
+	vars := make(state.Vars)
+	if varsFile != "" {
+		fileVars, err := state.VarsFromYamlPath(varsFile)
+		if err != nil {
+			return nil, err
+		}
+		vars.Merge(fileVars)
+	}
+	cliVars, err := state.VarsFromPairs(varPairs)
+	if err != nil {
+		return nil, err
+	}
+	vars.Merge(cliVars)
+	return vars, nil
+}
+
+/*
+Return the states targeted to this host, labels from --label are added to its facts
+*/
+func LocalStateMap(stateMap *state.StateMap) (*state.StateMap, error) {
+	labels, err := ParseLabels(labelPairs)
+	if err != nil {
+		return nil, err
+	}
+	facts := helpers.GatherFacts(labels)
+	return stateMap.ForHost(facts["hostname"], facts)
+}
+
+/*
+Parse labels from a list of key=value pairs
+*/
+func ParseLabels(pairs []string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, pair := range pairs {
+		split := strings.SplitN(pair, "=", 2)
+		if len(split) != 2 || split[0] == "" {
+			return nil, fmt.Errorf("Invalid label %q, expected key=value", pair)
+		}
+		labels[split[0]] = split[1]
+	}
+	return labels, nil
+}
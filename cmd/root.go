@@ -29,9 +29,12 @@ import (
 )
 
 var (
-	cfgFile  string
-	etcdStr  string
-	runLocal bool
+	cfgFile    string
+	etcdStr    string
+	runLocal   bool
+	varsFile   string
+	varPairs   []string
+	labelPairs []string
 )
 
 // This represents the base command when called without any subcommands
@@ -47,7 +50,7 @@ var RootCmd = &cobra.Command{
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
 	if err := RootCmd.Execute(); err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(-1)
 	}
 }
@@ -62,6 +65,9 @@ func init() {
 	RootCmd.PersistentFlags().StringVar(&etcdStr, "etcd", "http://127.0.0.1:2379", "etcd urls seperated by string (default is http://127.0.0.1:2379")
 	RootCmd.PersistentFlags().BoolVar(&runLocal, "local", false, "run the specified command locally")
 	RootCmd.PersistentFlags().StringVar(&cfgFile, "state", "", "state configuration file (default is $HOME/.otter.yaml)")
+	RootCmd.PersistentFlags().StringVar(&varsFile, "vars-file", "", "YAML file of variables overriding those in the state configuration")
+	RootCmd.PersistentFlags().StringArrayVar(&varPairs, "var", []string{}, "variable as key=value overriding the vars file, may be repeated")
+	RootCmd.PersistentFlags().StringArrayVar(&labelPairs, "label", []string{}, "label of this host as key=value added to its facts for targeting, may be repeated")
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -76,6 +82,6 @@ func initConfig() {
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
 	}
 }
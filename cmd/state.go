@@ -3,7 +3,6 @@ package cmd
 import (
 	"github.com/spf13/cobra"
 	"github.com/vektorlab/otter/client"
-	"github.com/vektorlab/otter/state"
 )
 
 // stateCmd represents the state command
@@ -12,20 +11,23 @@ var stateCmd = &cobra.Command{
 	Short: "Show the state of remote hosts in the cluster",
 	Long:  ``,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		stateMap, err := state.StateMapFromYamlPath(GetStatePath(cmd.Flag("state")))
+		stateMap, err := LoadStateMap(cmd.Flag("state"))
 		if err != nil {
 			return err
 		}
 		if cmd.Flag("local").Changed {
+			stateMap, err = LocalStateMap(stateMap)
+			if err != nil {
+				return err
+			}
 			DumpResults(stateMap.State())
 			return nil
 		} else {
 			client, err := clients.NewOtterClient(GetEtcdUrls(cmd.Flag("etcd")))
-			data, err := stateMap.ToJson()
 			if err != nil {
 				return err
 			}
-			client.SubmitState(string(data))
+			err = client.SubmitState(stateMap)
 			if err != nil {
 				return err
 			}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/vektorlab/otter/state"
+	"os"
+)
+
+var validateJson bool
+
+// validateCmd represents the validate command
+var validateCmd = &cobra.Command{
+	Use:   "validate [file]",
+	Short: "Check a state configuration for errors without applying it",
+	Long: `Load a state configuration and every file it includes without contacting etcd or changing the host.
+Each problem is reported with the file and line it was found at and the command exits non-zero if any are found.`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := GetStatePath(cmd.Flag("state"))
+		if len(args) > 0 {
+			path = args[0]
+			if _, err := os.Stat(path); err != nil {
+				return err
+			}
+		}
+		vars, err := StateVars()
+		if err != nil {
+			return err
+		}
+		var errs state.ValidationErrors
+		if _, err := state.StateMapFromYamlPathWithVars(path, vars); err != nil {
+			errs = state.AsValidationErrors(err, path)
+		}
+		if validateJson {
+			if errs == nil {
+				errs = make(state.ValidationErrors, 0)
+			}
+			data, err := json.MarshalIndent(errs, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+		} else {
+			for _, err := range errs {
+				fmt.Println(err)
+			}
+		}
+		if len(errs) > 0 {
+			return fmt.Errorf("%s is not a valid state configuration", path)
+		}
+		return nil
+	},
+}
+
+func init() {
+	validateCmd.Flags().BoolVar(&validateJson, "json", false, "report errors as a JSON array")
+	RootCmd.AddCommand(validateCmd)
+}
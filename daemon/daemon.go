@@ -1,9 +1,11 @@
 package daemon
 
 import (
+	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"github.com/vektorlab/otter/client"
 	"github.com/vektorlab/otter/helpers"
+	"github.com/vektorlab/otter/state"
 	"os"
 	"time"
 )
@@ -12,6 +14,7 @@ type Daemon struct {
 	otter    *clients.Otter
 	last     string
 	firstRun bool
+	labels   map[string]string // Labels published with the host's facts
 }
 
 func (daemon *Daemon) register() error {
@@ -20,7 +23,7 @@ func (daemon *Daemon) register() error {
 		return err
 	}
 	daemon.last = last
-	return nil
+	return daemon.otter.UpdateFacts(daemon.otter.Hostname, helpers.GatherFacts(daemon.labels))
 }
 
 func (daemon *Daemon) synchronize() {
@@ -53,16 +56,27 @@ func (daemon *Daemon) ProcessCommand(command, id string) error {
 	switch command {
 	case "apply":
 		stateMap, err := daemon.otter.RetrieveStateMap()
-		helpers.FailOnError(err, "Unable to retrieve StateMap from Etcd")
+		if err != nil {
+			return daemon.fault(id, err)
+		}
 		return daemon.otter.SaveResultMap(id, stateMap.Apply())
 	case "state":
 		stateMap, err := daemon.otter.RetrieveStateMap()
-		helpers.FailOnError(err, "Unable to retrieve StateMap from Etcd")
+		if err != nil {
+			return daemon.fault(id, err)
+		}
 		return daemon.otter.SaveResultMap(id, stateMap.State())
 	default:
-		log.Fatalf("Unknown command: %s (%s)", command, id)
+		return daemon.fault(id, fmt.Errorf("Unknown command: %s", command))
 	}
-	return nil
+}
+
+/*
+Report a command which could not be processed as a Faulted Result so the daemon keeps running
+*/
+func (daemon *Daemon) fault(id string, err error) error {
+	log.Printf("Unable to process command %s: %s", id, err)
+	return daemon.otter.SaveResultMap(id, state.ResultMapFromError(daemon.otter.Hostname, err))
 }
 
 func (daemon *Daemon) Run() {
@@ -71,10 +85,11 @@ func (daemon *Daemon) Run() {
 	select {}
 }
 
-func NewDaemon(servers []string) (*Daemon, error) {
+func NewDaemon(servers []string, labels map[string]string) (*Daemon, error) {
 	var err error
 	daemon := Daemon{
 		firstRun: true,
+		labels:   labels,
 	}
 	daemon.otter, err = clients.NewOtterClient(servers)
 	if err != nil {
@@ -0,0 +1,52 @@
+package helpers
+
+import (
+	"strings"
+)
+
+/*
+Produce a line based diff of two strings, removed lines are prefixed with "-" and added lines with "+"
+*/
+func Diff(before, after string) string {
+	a := splitLines(before)
+	b := splitLines(after)
+	// Longest common subsequence table, lcs[i][j] is the LCS length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	diff := make([]string, 0)
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] > lcs[i+1][j]):
+			diff = append(diff, "+"+b[j])
+			j++
+		default:
+			diff = append(diff, "-"+a[i])
+			i++
+		}
+	}
+	return strings.Join(diff, "\n")
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
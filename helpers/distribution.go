@@ -45,14 +45,21 @@ func (d *Distro) SetInitSystem() error {
 }
 
 /*
-Get an operating system's distribution type by parsing the /etc/os-release
+Get an operating system's distribution type by parsing the /etc/os-release, the family is read from ID_LIKE or
+from ID when the distribution is not derived from another
 */
 
 func GetDistro() (*Distro, error) {
 	d := Distro{}
 	if runtime.GOOS == "linux" {
 		i, err := ini.Load([]byte(""), "/etc/os-release")
+		if err != nil {
+			return nil, err
+		}
 		section, err := i.Section("").GetKey("ID_LIKE")
+		if err != nil {
+			section, err = i.Section("").GetKey("ID") // Debian has no ID_LIKE
+		}
 		if err != nil {
 			return nil, err
 		}
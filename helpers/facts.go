@@ -0,0 +1,24 @@
+package helpers
+
+import (
+	"runtime"
+)
+
+/*
+Gather the facts a host publishes for targeting, labels are added as facts and may override gathered ones
+*/
+func GatherFacts(labels map[string]string) map[string]string {
+	facts := map[string]string{
+		"hostname": GetHostName(),
+		"os":       runtime.GOOS,
+		"arch":     runtime.GOARCH,
+	}
+	if distro, err := GetDistro(); err == nil {
+		facts["family"] = distro.Family
+		facts["init"] = distro.InitSystem
+	}
+	for key, value := range labels {
+		facts[key] = value
+	}
+	return facts
+}
@@ -0,0 +1,426 @@
+/*
+An Archive represents a tar or zip archive extracted into a directory.
+The archive is retrieved from the same sources as a File and verified against a checksum, a marker file recording
+the checksum is written to the destination so the archive is only extracted again when the checksum changes.
+States -
+  extracted: The archive with the declared checksum has been extracted into the destination
+*/
+
+package state
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type Archive struct {
+	Source          string   `json:"source"`           // Archive source, an http://, https:// or file:// URL
+	Checksum        string   `json:"checksum"`         // Checksum of the archive, "sha256:<hex>", "sha512:<hex>" or a bare sha256
+	Destination     string   `json:"destination"`      // Directory the archive is extracted into, defaults to the state name
+	Format          string   `json:"format"`           // "tar.gz", "tar" or "zip", inferred from the source when empty
+	StripComponents int      `json:"strip-components"` // Number of leading path components removed from each entry
+	Include         []string `json:"include"`          // Only extract entries matching these glob patterns
+	Metadata        Metadata `json:"metadata"`
+}
+
+func (archive *Archive) Meta() Metadata {
+	return archive.Metadata
+}
+
+func (archive *Archive) State() *Result {
+	result := &Result{
+		Metadata:   &archive.Metadata,
+		Consistent: false,
+	}
+	data, err := ioutil.ReadFile(archive.markerPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			result.Message = fmt.Sprintf("%s has not been extracted to %s", archive.Source, archive.Destination)
+		} else {
+			result.Message = err.Error()
+		}
+		return result
+	}
+	extracted := strings.TrimSpace(string(data))
+	result.Details = map[string]string{"checksum": extracted}
+	if extracted != archive.Checksum {
+		result.Message = fmt.Sprintf("Extracted archive has checksum %s, expected %s", extracted, archive.Checksum)
+		return result
+	}
+	result.Consistent = true
+	return result
+}
+
+func (archive *Archive) Apply() *Result {
+	result := archive.State()
+	if result.Consistent == true {
+		return result
+	}
+	data, err := retrieveSource(archive.Source)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	err = archive.verify(data)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	err = os.MkdirAll(archive.Destination, 0755)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	count := 0
+	switch archive.Format {
+	case "zip":
+		count, err = archive.extractZip(data)
+	default:
+		count, err = archive.extractTar(data)
+	}
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	err = ioutil.WriteFile(archive.markerPath(), []byte(archive.Checksum+"\n"), 0644)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	result.Message = fmt.Sprintf("Extracted %d files to %s", count, archive.Destination)
+	result.Details = map[string]string{"checksum": archive.Checksum}
+	result.Consistent = true
+	return result
+}
+
+/*
+Create and validate a new Archive State
+*/
+func newArchive(metadata Metadata, data []byte) (*Archive, error) {
+	archive := &Archive{}
+	err := json.Unmarshal(data, &archive)
+	if err != nil {
+		return nil, err
+	}
+	archive.Metadata = metadata
+	switch metadata.State {
+	case "extracted":
+	default:
+		return nil, fmt.Errorf("Invalid archive state: %s", metadata.State)
+	}
+	if archive.Destination == "" {
+		archive.Destination = metadata.Name
+	}
+	if archive.Source == "" {
+		return nil, fmt.Errorf("No source specified for archive %s", metadata.Name)
+	}
+	if archive.Format == "" {
+		switch {
+		case strings.HasSuffix(archive.Source, ".tar.gz"), strings.HasSuffix(archive.Source, ".tgz"):
+			archive.Format = "tar.gz"
+		case strings.HasSuffix(archive.Source, ".tar"):
+			archive.Format = "tar"
+		case strings.HasSuffix(archive.Source, ".zip"):
+			archive.Format = "zip"
+		default:
+			return nil, fmt.Errorf("Unable to infer the archive format of %s", archive.Source)
+		}
+	}
+	switch archive.Format {
+	case "tar.gz", "tar", "zip":
+	default:
+		return nil, fmt.Errorf("Unsupported archive format: %s", archive.Format)
+	}
+	if archive.StripComponents < 0 {
+		return nil, fmt.Errorf("Invalid strip-components for archive %s: %d", metadata.Name, archive.StripComponents)
+	}
+	for _, pattern := range archive.Include {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Invalid include pattern %q: %s", pattern, err)
+		}
+	}
+	archive.Checksum = strings.ToLower(archive.Checksum)
+	if !strings.Contains(archive.Checksum, ":") {
+		archive.Checksum = "sha256:" + archive.Checksum
+	}
+	if _, _, err := archive.hash(); err != nil {
+		return nil, err
+	}
+	return archive, nil
+}
+
+/*
+Return the hash function and expected digest of the declared checksum
+*/
+func (archive *Archive) hash() (hash.Hash, string, error) {
+	split := strings.SplitN(archive.Checksum, ":", 2)
+	var h hash.Hash
+	switch split[0] {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return nil, "", fmt.Errorf("Unsupported checksum algorithm: %s", split[0])
+	}
+	if _, err := hex.DecodeString(split[1]); err != nil || len(split[1]) != h.Size()*2 {
+		return nil, "", fmt.Errorf("Invalid %s checksum: %s", split[0], split[1])
+	}
+	return h, split[1], nil
+}
+
+/*
+Verify the retrieved archive matches the declared checksum
+*/
+func (archive *Archive) verify(data []byte) error {
+	h, expected, err := archive.hash()
+	if err != nil {
+		return err
+	}
+	h.Write(data)
+	actual := hex.EncodeToString(h.Sum(nil))
+	if actual != expected {
+		return fmt.Errorf("Checksum mismatch for %s: got %s, expected %s", archive.Source, actual, expected)
+	}
+	return nil
+}
+
+/*
+The marker file is named after the state so several archives may be extracted into one directory
+*/
+func (archive *Archive) markerPath() string {
+	sum := sha256.Sum256([]byte(archive.Metadata.Name))
+	return filepath.Join(archive.Destination, ".otter-archive-"+hex.EncodeToString(sum[:])[:12])
+}
+
+/*
+Return the destination of an archive entry, or an empty string if the entry is not extracted
+*/
+func (archive *Archive) target(name string) (string, error) {
+	name = path.Clean(strings.TrimPrefix(name, "./"))
+	components := strings.Split(name, "/")
+	if len(components) <= archive.StripComponents {
+		return "", nil
+	}
+	name = path.Join(components[archive.StripComponents:]...)
+	if len(archive.Include) > 0 {
+		included := false
+		for _, pattern := range archive.Include {
+			if matched, _ := path.Match(pattern, name); matched {
+				included = true
+			}
+		}
+		if !included {
+			return "", nil
+		}
+	}
+	if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+		return "", fmt.Errorf("Archive entry escapes the destination: %s", name)
+	}
+	return filepath.Join(archive.Destination, filepath.FromSlash(name)), nil
+}
+
+/*
+Check a path resolves inside the destination once the symlinks already on disk are followed
+*/
+func (archive *Archive) checkPath(target string) error {
+	destination, err := resolvePath(archive.Destination)
+	if err != nil {
+		return err
+	}
+	resolved, err := resolvePath(target)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(destination, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("Archive entry %s escapes the destination", target)
+	}
+	return nil
+}
+
+/*
+Check a symlink resolves inside the destination so later entries can not be written through it to other paths
+*/
+func (archive *Archive) checkLink(target, linkname string) error {
+	parent, err := resolvePath(filepath.Dir(target))
+	if err != nil {
+		return err
+	}
+	resolved := linkname
+	if !filepath.IsAbs(resolved) {
+		resolved = parent + string(filepath.Separator) + resolved // Not joined, joining would clean ".." before links are followed
+	}
+	if archive.checkPath(parent) != nil || archive.checkPath(resolved) != nil {
+		return fmt.Errorf("Archive symlink %s escapes the destination: %s", target, linkname)
+	}
+	return nil
+}
+
+/*
+Resolve the symlinks of a path which may not exist yet, the missing components are joined to the longest existing prefix
+*/
+func resolvePath(target string) (string, error) {
+	target, err := filepath.Abs(target)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(target)
+	if err == nil || !os.IsNotExist(err) {
+		return resolved, err
+	}
+	i := strings.LastIndex(target, string(filepath.Separator))
+	if i <= 0 {
+		return target, nil
+	}
+	parent, err := resolvePath(target[:i])
+	if err != nil {
+		return "", err
+	}
+	name := target[i+1:]
+	if link, err := os.Readlink(filepath.Join(parent, name)); err == nil { // A dangling symlink
+		if !filepath.IsAbs(link) {
+			link = parent + string(filepath.Separator) + link
+		}
+		return resolvePath(link)
+	}
+	return filepath.Join(parent, name), nil
+}
+
+/*
+Extract a tar or gzip compressed tar archive, returning the number of extracted files
+*/
+func (archive *Archive) extractTar(data []byte) (int, error) {
+	var reader io.Reader = bytes.NewReader(data)
+	if archive.Format == "tar.gz" {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return 0, err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+	count := 0
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		target, err := archive.target(header.Name)
+		if err != nil {
+			return count, err
+		}
+		if target == "" {
+			continue
+		}
+		mode := os.FileMode(header.Mode).Perm()
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = archive.checkPath(target)
+			if err == nil {
+				err = os.MkdirAll(target, mode|0700)
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			err = archive.checkPath(target)
+			if err == nil {
+				err = writeArchiveEntry(target, tr, mode)
+			}
+			count++
+		case tar.TypeSymlink:
+			err = archive.checkLink(target, header.Linkname)
+			if err == nil {
+				err = os.MkdirAll(filepath.Dir(target), 0755)
+			}
+			if err == nil {
+				os.Remove(target)
+				err = os.Symlink(header.Linkname, target)
+			}
+			count++
+		default:
+			log.Printf("Skipping unsupported archive entry: %s", header.Name)
+		}
+		if err != nil {
+			return count, err
+		}
+	}
+}
+
+/*
+Extract a zip archive, returning the number of extracted files
+*/
+func (archive *Archive) extractZip(data []byte) (int, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, f := range zr.File {
+		target, err := archive.target(f.Name)
+		if err != nil {
+			return count, err
+		}
+		if target == "" {
+			continue
+		}
+		err = archive.checkPath(target)
+		if err != nil {
+			return count, err
+		}
+		if f.FileInfo().IsDir() {
+			err = os.MkdirAll(target, f.Mode().Perm()|0700)
+			if err != nil {
+				return count, err
+			}
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return count, err
+		}
+		err = writeArchiveEntry(target, rc, f.Mode().Perm())
+		rc.Close()
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+func writeArchiveEntry(target string, reader io.Reader, mode os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, reader)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Chmod(target, mode)
+}
@@ -0,0 +1,173 @@
+package state
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/*
+Write a tar.gz archive containing the given files and return its path and sha256 checksum
+*/
+func writeTestArchive(dir string, files map[string]string, t *testing.T) (string, string) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, name := range []string{"etcd-v3.2.9/", "etcd-v3.2.9/etcd", "etcd-v3.2.9/etcdctl", "etcd-v3.2.9/README.md"} {
+		content, ok := files[name]
+		if !ok && name != "etcd-v3.2.9/" {
+			continue
+		}
+		header := &tar.Header{Name: name, Mode: 0755, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if name == "etcd-v3.2.9/" {
+			header.Typeflag = tar.TypeDir
+		}
+		tw.WriteHeader(header)
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	gz.Close()
+	path := filepath.Join(dir, "etcd.tar.gz")
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return path, hex.EncodeToString(sum[:])
+}
+
+func archiveSetup(dir, source, checksum, data string, t *testing.T) State {
+	metadata := Metadata{Name: "etcd", Type: "archive", State: "extracted"}
+	return stateSetup(metadata, []byte(fmt.Sprintf(`{"source": "file://%s", "checksum": "%s", "destination": "%s" %s}`, source, checksum, filepath.Join(dir, "bin"), data)), t)
+}
+
+func TestArchiveExtracted(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "otter-archive")
+	defer os.RemoveAll(dir)
+	source, checksum := writeTestArchive(dir, map[string]string{"etcd-v3.2.9/etcd": "etcd", "etcd-v3.2.9/etcdctl": "etcdctl", "etcd-v3.2.9/README.md": "readme"}, t)
+	state := archiveSetup(dir, source, checksum, `, "strip-components": 1, "include": ["etcd*"]`, t)
+	if state.State().Consistent {
+		fmt.Println("Archive should not be extracted")
+		t.Fail()
+	}
+	result := state.Apply()
+	if !result.Consistent || result.Details["checksum"] != "sha256:"+checksum {
+		fmt.Println("Failed to extract archive: ", result.Message)
+		t.Fail()
+	}
+	data, _ := ioutil.ReadFile(filepath.Join(dir, "bin", "etcdctl"))
+	if string(data) != "etcdctl" {
+		fmt.Println("Bad extracted file: ", string(data))
+		t.Fail()
+	}
+	if _, err := os.Stat(filepath.Join(dir, "bin", "README.md")); !os.IsNotExist(err) {
+		fmt.Println("Excluded file was extracted")
+		t.Fail()
+	}
+	if !state.State().Consistent {
+		fmt.Println("Archive should be extracted")
+		t.Fail()
+	}
+	// A new checksum causes the archive to be extracted again
+	source, checksum = writeTestArchive(dir, map[string]string{"etcd-v3.2.9/etcd": "etcd v2"}, t)
+	state = archiveSetup(dir, source, checksum, `, "strip-components": 1`, t)
+	if state.State().Consistent {
+		fmt.Println("Archive with a new checksum should not be consistent")
+		t.Fail()
+	}
+	state.Apply()
+	data, _ = ioutil.ReadFile(filepath.Join(dir, "bin", "etcd"))
+	if string(data) != "etcd v2" {
+		fmt.Println("Archive was not extracted again: ", string(data))
+		t.Fail()
+	}
+}
+
+func TestArchiveChecksumMismatch(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "otter-archive")
+	defer os.RemoveAll(dir)
+	source, _ := writeTestArchive(dir, map[string]string{"etcd-v3.2.9/etcd": "etcd"}, t)
+	sum := sha256.Sum256([]byte("something else"))
+	state := archiveSetup(dir, source, "sha256:"+hex.EncodeToString(sum[:]), "", t)
+	if state.Apply().Consistent {
+		fmt.Println("Archive with a bad checksum should not be extracted")
+		t.Fail()
+	}
+	if _, err := os.Stat(filepath.Join(dir, "bin", "etcd-v3.2.9")); !os.IsNotExist(err) {
+		fmt.Println("Archive with a bad checksum was extracted")
+		t.Fail()
+	}
+}
+
+func TestArchiveEscape(t *testing.T) {
+	archive := &Archive{Destination: "/opt/etcd"}
+	if _, err := archive.target("../../etc/passwd"); err == nil {
+		fmt.Println("Archive entry escaping the destination should fail")
+		t.Fail()
+	}
+	if target, _ := archive.target("./bin/etcd"); target != "/opt/etcd/bin/etcd" {
+		fmt.Println("Bad archive target: ", target)
+		t.Fail()
+	}
+}
+
+func TestArchiveSymlinkEscape(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "otter-archive")
+	defer os.RemoveAll(dir)
+	outside := filepath.Join(dir, "outside")
+	os.MkdirAll(outside, 0755)
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "a", Typeflag: tar.TypeSymlink, Linkname: outside})
+	tw.WriteHeader(&tar.Header{Name: "a/passwd", Mode: 0644, Size: 4, Typeflag: tar.TypeReg})
+	tw.Write([]byte("root"))
+	tw.Close()
+	archive := &Archive{Destination: filepath.Join(dir, "bin"), Format: "tar"}
+	if _, err := archive.extractTar(buf.Bytes()); err == nil || !strings.Contains(err.Error(), "escapes the destination") {
+		fmt.Println("Symlink escaping the destination should fail: ", err)
+		t.Fail()
+	}
+	if _, err := os.Stat(filepath.Join(outside, "passwd")); err == nil {
+		fmt.Println("Archive entry was written through a symlink outside the destination")
+		t.Fail()
+	}
+	if err := archive.checkLink(filepath.Join(dir, "bin", "lib", "current"), "../share"); err != nil {
+		fmt.Println("Symlink inside the destination should be allowed: ", err)
+		t.Fail()
+	}
+}
+
+func TestArchiveChainedSymlinkEscape(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "otter-archive")
+	defer os.RemoveAll(dir)
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "."})
+	tw.WriteHeader(&tar.Header{Name: "b", Typeflag: tar.TypeSymlink, Linkname: "a/.."})
+	tw.WriteHeader(&tar.Header{Name: "b/evil", Mode: 0644, Size: 4, Typeflag: tar.TypeReg})
+	tw.Write([]byte("evil"))
+	tw.Close()
+	archive := &Archive{Destination: filepath.Join(dir, "bin"), Format: "tar"}
+	os.MkdirAll(archive.Destination, 0755)
+	if _, err := archive.extractTar(buf.Bytes()); err == nil || !strings.Contains(err.Error(), "escapes the destination") {
+		fmt.Println("Chained symlink escaping the destination should fail: ", err)
+		t.Fail()
+	}
+	if _, err := os.Stat(filepath.Join(dir, "evil")); err == nil {
+		fmt.Println("Archive entry was written through a chained symlink outside the destination")
+		t.Fail()
+	}
+	// An entry written through a symlink already on disk is checked after the link is followed
+	os.Symlink(dir, filepath.Join(archive.Destination, "up"))
+	if err := archive.checkPath(filepath.Join(archive.Destination, "up", "evil")); err == nil {
+		fmt.Println("Entry written through an existing symlink outside the destination should fail")
+		t.Fail()
+	}
+}
@@ -0,0 +1,140 @@
+/*
+A Block represents a block of lines delimited by marker comments within a file which is otherwise not managed by Otter.
+States -
+  present: The block is present with the declared content
+*/
+
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/vektorlab/otter/helpers"
+	"strings"
+)
+
+type Block struct {
+	Path     string   `json:"path"`    // File containing the block
+	Content  string   `json:"content"` // Lines between the markers
+	Comment  string   `json:"comment"` // Comment prefix used for the markers, defaults to "#"
+	Create   bool     `json:"create"`  // Create the file if it does not exist
+	Metadata Metadata `json:"metadata"`
+}
+
+func (block *Block) Meta() Metadata {
+	return block.Metadata
+}
+
+func (block *Block) State() *Result {
+	result := &Result{
+		Metadata:   &block.Metadata,
+		Consistent: false,
+	}
+	content, err := readManagedFile(block.Path, block.Create)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	edited, err := block.edit(content)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	if diff := helpers.Diff(content, edited); diff != "" {
+		result.Message = fmt.Sprintf("%s would change", block.Path)
+		result.Details = map[string]string{"diff": diff}
+		return result
+	}
+	result.Consistent = true
+	return result
+}
+
+func (block *Block) Apply() *Result {
+	result := block.State()
+	if result.Consistent == true {
+		return result
+	}
+	content, err := readManagedFile(block.Path, block.Create)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	edited, err := block.edit(content)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	err = writeManagedFile(block.Path, edited)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	result.Message = fmt.Sprintf("%s updated", block.Path)
+	result.Consistent = true
+	return result
+}
+
+/*
+Create and validate a new Block State
+*/
+func newBlock(metadata Metadata, data []byte) (*Block, error) {
+	block := &Block{}
+	err := json.Unmarshal(data, &block)
+	if err != nil {
+		return nil, err
+	}
+	block.Metadata = metadata
+	switch metadata.State {
+	case "present":
+	default:
+		return nil, fmt.Errorf("Invalid block state: %s", metadata.State)
+	}
+	if block.Path == "" {
+		return nil, fmt.Errorf("No path specified for %s", metadata.Name)
+	}
+	if block.Comment == "" {
+		block.Comment = "#"
+	}
+	return block, nil
+}
+
+/*
+Return the markers delimiting the block
+*/
+func (block *Block) markers() (string, string) {
+	return fmt.Sprintf("%s BEGIN OTTER MANAGED BLOCK: %s", block.Comment, block.Metadata.Name),
+		fmt.Sprintf("%s END OTTER MANAGED BLOCK: %s", block.Comment, block.Metadata.Name)
+}
+
+/*
+Return the content of the file with the block replaced, or appended if the markers are not found
+*/
+func (block *Block) edit(content string) (string, error) {
+	begin, end := block.markers()
+	lines := splitFileLines(content)
+	managed := append([]string{begin}, splitFileLines(block.Content)...)
+	managed = append(managed, end)
+	start, stop := -1, -1
+	for i, line := range lines {
+		switch strings.TrimSpace(line) {
+		case begin:
+			if start == -1 {
+				start = i
+			}
+		case end:
+			if start != -1 && stop == -1 {
+				stop = i
+			}
+		}
+	}
+	switch {
+	case start == -1 && stop == -1:
+		return joinFileLines(append(lines, managed...)), nil
+	case start != -1 && stop == -1:
+		return "", fmt.Errorf("Found %q without a matching end marker in %s", begin, block.Path)
+	}
+	edited := append([]string{}, lines[:start]...)
+	edited = append(edited, managed...)
+	edited = append(edited, lines[stop+1:]...)
+	return joinFileLines(edited), nil
+}
@@ -0,0 +1,64 @@
+package state
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var etcHosts = `127.0.0.1 localhost
+# BEGIN OTTER MANAGED BLOCK: cluster
+10.0.0.1 old-master
+# END OTTER MANAGED BLOCK: cluster
+::1 localhost
+`
+
+func blockSetup(content string, t *testing.T) (State, string) {
+	dir, err := ioutil.TempDir("", "otter-block")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "hosts")
+	ioutil.WriteFile(path, []byte(content), 0644)
+	metadata := Metadata{Name: "cluster", Type: "block", State: "present"}
+	data := fmt.Sprintf(`{"path": "%s", "content": "10.0.0.2 master\n10.0.0.3 worker\n"}`, path)
+	return stateSetup(metadata, []byte(data), t), path
+}
+
+func TestBlockReplace(t *testing.T) {
+	state, path := blockSetup(etcHosts, t)
+	defer os.RemoveAll(filepath.Dir(path))
+	result := state.State()
+	if result.Consistent || result.Details["diff"] != "-10.0.0.1 old-master\n+10.0.0.2 master\n+10.0.0.3 worker" {
+		fmt.Println("Bad block diff: ", result.Details["diff"])
+		t.Fail()
+	}
+	state.Apply()
+	data, _ := ioutil.ReadFile(path)
+	expected := "127.0.0.1 localhost\n# BEGIN OTTER MANAGED BLOCK: cluster\n10.0.0.2 master\n10.0.0.3 worker\n# END OTTER MANAGED BLOCK: cluster\n::1 localhost\n"
+	if string(data) != expected {
+		fmt.Println("Bad block replacement: ", string(data))
+		t.Fail()
+	}
+}
+
+func TestBlockAppend(t *testing.T) {
+	state, path := blockSetup("127.0.0.1 localhost\n", t)
+	defer os.RemoveAll(filepath.Dir(path))
+	state.Apply()
+	if !state.State().Consistent {
+		fmt.Println("Block not consistent after apply")
+		t.Fail()
+	}
+}
+
+func TestBlockMissingEndMarker(t *testing.T) {
+	state, path := blockSetup("# BEGIN OTTER MANAGED BLOCK: cluster\n10.0.0.1 old-master\n", t)
+	defer os.RemoveAll(filepath.Dir(path))
+	if result := state.Apply(); result.Consistent {
+		fmt.Println("Failed to detect missing end marker")
+		t.Fail()
+	}
+}
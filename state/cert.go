@@ -0,0 +1,497 @@
+/*
+A Cert represents a private key and an X.509 certificate or certificate signing request generated from it.
+Certificates are self-signed unless CA material is provided, a CSR is written instead when csr is set.
+States -
+  present: The key exists and the certificate matches the declared subject and is not within the renewal window
+*/
+
+package state
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Cert struct {
+	Cert         string   `json:"cert"`         // Path of the certificate, defaults to the state name
+	Key          string   `json:"key"`          // Path of the private key, an existing key is reused
+	CSR          string   `json:"csr"`          // Path of a certificate signing request, written instead of a certificate
+	CommonName   string   `json:"common-name"`  // Common name of the subject
+	Organization []string `json:"organization"` // Organizations of the subject
+	DNSNames     []string `json:"dns-names"`    // DNS subject alternative names
+	IPAddresses  []string `json:"ip-addresses"` // IP subject alternative names
+	Usages       []string `json:"usages"`       // Extended key usages, "server" and/or "client", defaults to both
+	KeyType      string   `json:"key-type"`     // "rsa" or "ecdsa", defaults to "rsa"
+	Bits         int      `json:"bits"`         // Size of RSA keys, defaults to 2048
+	Days         int      `json:"days"`         // Validity of the certificate in days, defaults to 365
+	Renew        int      `json:"renew"`        // Renew the certificate this many days before it expires, defaults to 30
+	IsCA         bool     `json:"ca"`           // Generate a certificate authority
+	CACert       string   `json:"ca-cert"`      // Path of the certificate authority which signs the certificate
+	CAKey        string   `json:"ca-key"`       // Path of the private key of the certificate authority
+	Metadata     Metadata `json:"metadata"`
+}
+
+func (cert *Cert) Meta() Metadata {
+	return cert.Metadata
+}
+
+func (cert *Cert) State() *Result {
+	result := &Result{
+		Metadata:   &cert.Metadata,
+		Consistent: false,
+	}
+	key, err := readPrivateKey(cert.Key)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	if key == nil {
+		result.Message = fmt.Sprintf("Private key %s does not exist", cert.Key)
+		return result
+	}
+	if keyType(key) != cert.KeyType {
+		result.Message = fmt.Sprintf("Private key %s is not an %s key", cert.Key, cert.KeyType)
+		return result
+	}
+	if cert.CSR != "" {
+		result.Message, err = cert.checkCSR(key)
+	} else {
+		result.Details, result.Message, err = cert.checkCert(key)
+	}
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	if result.Message != "" {
+		return result
+	}
+	result.Consistent = true
+	return result
+}
+
+func (cert *Cert) Apply() *Result {
+	result := cert.State()
+	if result.Consistent == true {
+		return result
+	}
+	key, err := readPrivateKey(cert.Key)
+	if err == nil && (key == nil || keyType(key) != cert.KeyType) {
+		key, err = cert.generateKey()
+	}
+	if err == nil {
+		if cert.CSR != "" {
+			err = cert.writeCSR(key)
+		} else {
+			result.Details, err = cert.writeCert(key)
+		}
+	}
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	if cert.CSR != "" {
+		result.Message = fmt.Sprintf("Certificate signing request written to %s", cert.CSR)
+	} else {
+		result.Message = fmt.Sprintf("Certificate written to %s", cert.Cert)
+	}
+	result.Consistent = true
+	return result
+}
+
+/*
+Create and validate a new Cert State
+*/
+func newCert(metadata Metadata, data []byte) (*Cert, error) {
+	cert := &Cert{}
+	err := json.Unmarshal(data, &cert)
+	if err != nil {
+		return nil, err
+	}
+	cert.Metadata = metadata
+	switch metadata.State {
+	case "present":
+	default:
+		return nil, fmt.Errorf("Invalid cert state: %s", metadata.State)
+	}
+	if cert.Cert == "" && cert.CSR == "" {
+		cert.Cert = metadata.Name
+	}
+	if cert.Cert != "" && cert.CSR != "" {
+		return nil, fmt.Errorf("Cert %s may not write both a certificate and a CSR", metadata.Name)
+	}
+	if cert.Key == "" {
+		return nil, fmt.Errorf("No key specified for cert %s", metadata.Name)
+	}
+	if cert.CommonName == "" {
+		return nil, fmt.Errorf("No common-name specified for cert %s", metadata.Name)
+	}
+	if (cert.CACert == "") != (cert.CAKey == "") {
+		return nil, fmt.Errorf("Cert %s requires both ca-cert and ca-key", metadata.Name)
+	}
+	if cert.KeyType == "" {
+		cert.KeyType = "rsa"
+	}
+	switch cert.KeyType {
+	case "rsa":
+		if cert.Bits == 0 {
+			cert.Bits = 2048
+		}
+		if cert.Bits < 2048 {
+			return nil, fmt.Errorf("RSA keys for cert %s must be at least 2048 bits", metadata.Name)
+		}
+	case "ecdsa":
+	default:
+		return nil, fmt.Errorf("Invalid key-type for cert %s: %s", metadata.Name, cert.KeyType)
+	}
+	if len(cert.Usages) == 0 && !cert.IsCA {
+		cert.Usages = []string{"server", "client"}
+	}
+	for _, usage := range cert.Usages {
+		if usage != "server" && usage != "client" {
+			return nil, fmt.Errorf("Invalid usage for cert %s: %s", metadata.Name, usage)
+		}
+	}
+	for _, ip := range cert.IPAddresses {
+		if net.ParseIP(ip) == nil {
+			return nil, fmt.Errorf("Invalid ip-address for cert %s: %s", metadata.Name, ip)
+		}
+	}
+	if cert.Days == 0 {
+		cert.Days = 365
+	}
+	if cert.Renew == 0 {
+		cert.Renew = 30
+	}
+	if cert.Days <= cert.Renew {
+		return nil, fmt.Errorf("Cert %s would be renewed immediately, days must be greater than renew", metadata.Name)
+	}
+	return cert, nil
+}
+
+/*
+Compare an existing certificate with the declared one, returning its details and the reason it must be regenerated
+*/
+func (cert *Cert) checkCert(key crypto.Signer) (map[string]string, string, error) {
+	existing, err := readCertificate(cert.Cert)
+	if err != nil {
+		return nil, "", err
+	}
+	if existing == nil {
+		return nil, fmt.Sprintf("Certificate %s does not exist", cert.Cert), nil
+	}
+	details := certDetails(existing)
+	if !samePublicKey(existing.PublicKey, key) {
+		return details, fmt.Sprintf("Certificate %s does not match key %s", cert.Cert, cert.Key), nil
+	}
+	if reason := cert.compareSubject(existing.Subject, existing.DNSNames, existing.IPAddresses); reason != "" {
+		return details, reason, nil
+	}
+	if existing.IsCA != cert.IsCA {
+		return details, fmt.Sprintf("Certificate %s has CA %t, expected %t", cert.Cert, existing.IsCA, cert.IsCA), nil
+	}
+	if cert.CACert != "" {
+		ca, err := readCertificate(cert.CACert)
+		if err != nil {
+			return details, "", err
+		}
+		if ca == nil || existing.CheckSignatureFrom(ca) != nil {
+			return details, fmt.Sprintf("Certificate %s is not signed by %s", cert.Cert, cert.CACert), nil
+		}
+	}
+	if time.Until(existing.NotAfter) < time.Duration(cert.Renew)*24*time.Hour {
+		return details, fmt.Sprintf("Certificate %s expires at %s", cert.Cert, details["expiry"]), nil
+	}
+	return details, "", nil
+}
+
+/*
+Compare an existing CSR with the declared one, returning the reason it must be regenerated
+*/
+func (cert *Cert) checkCSR(key crypto.Signer) (string, error) {
+	data, err := ioutil.ReadFile(cert.CSR)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Sprintf("Certificate signing request %s does not exist", cert.CSR), nil
+		}
+		return "", err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return fmt.Sprintf("%s does not contain a certificate signing request", cert.CSR), nil
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return "", err
+	}
+	if !samePublicKey(csr.PublicKey, key) {
+		return fmt.Sprintf("Certificate signing request %s does not match key %s", cert.CSR, cert.Key), nil
+	}
+	return cert.compareSubject(csr.Subject, csr.DNSNames, csr.IPAddresses), nil
+}
+
+func (cert *Cert) compareSubject(subject pkix.Name, dnsNames []string, ipAddresses []net.IP) string {
+	if subject.CommonName != cert.CommonName {
+		return fmt.Sprintf("Common name is %q, expected %q", subject.CommonName, cert.CommonName)
+	}
+	if !sameStrings(subject.Organization, cert.Organization) {
+		return fmt.Sprintf("Organization is %v, expected %v", subject.Organization, cert.Organization)
+	}
+	if !sameStrings(dnsNames, cert.DNSNames) {
+		return fmt.Sprintf("DNS names are %v, expected %v", dnsNames, cert.DNSNames)
+	}
+	ips := make([]string, 0)
+	for _, ip := range ipAddresses {
+		ips = append(ips, ip.String())
+	}
+	expected := make([]string, 0)
+	for _, ip := range cert.IPAddresses {
+		expected = append(expected, net.ParseIP(ip).String())
+	}
+	if !sameStrings(ips, expected) {
+		return fmt.Sprintf("IP addresses are %v, expected %v", ips, expected)
+	}
+	return ""
+}
+
+func (cert *Cert) subject() pkix.Name {
+	return pkix.Name{CommonName: cert.CommonName, Organization: cert.Organization}
+}
+
+func (cert *Cert) ipAddresses() []net.IP {
+	ips := make([]net.IP, 0)
+	for _, ip := range cert.IPAddresses {
+		ips = append(ips, net.ParseIP(ip))
+	}
+	return ips
+}
+
+/*
+Generate a new private key and write it to the key path
+*/
+func (cert *Cert) generateKey() (crypto.Signer, error) {
+	var (
+		key crypto.Signer
+		der []byte
+		err error
+	)
+	block := &pem.Block{}
+	log.Printf("Generating %s private key %s", cert.KeyType, cert.Key)
+	switch cert.KeyType {
+	case "ecdsa":
+		var ecKey *ecdsa.PrivateKey
+		ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err == nil {
+			key = ecKey
+			der, err = x509.MarshalECPrivateKey(ecKey)
+			block.Type = "EC PRIVATE KEY"
+		}
+	default:
+		var rsaKey *rsa.PrivateKey
+		rsaKey, err = rsa.GenerateKey(rand.Reader, cert.Bits)
+		if err == nil {
+			key = rsaKey
+			der = x509.MarshalPKCS1PrivateKey(rsaKey)
+			block.Type = "RSA PRIVATE KEY"
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	block.Bytes = der
+	return key, writePEM(cert.Key, block, 0600)
+}
+
+/*
+Write a certificate signed by the CA, or a self-signed certificate, returning its details
+*/
+func (cert *Cert) writeCert(key crypto.Signer) (map[string]string, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               cert.subject(),
+		DNSNames:              cert.DNSNames,
+		IPAddresses:           cert.ipAddresses(),
+		NotBefore:             now.Add(-5 * time.Minute), // Allow for clock skew between hosts
+		NotAfter:              now.Add(time.Duration(cert.Days) * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		BasicConstraintsValid: true,
+		IsCA:                  cert.IsCA,
+	}
+	if cert.IsCA {
+		template.KeyUsage |= x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	}
+	for _, usage := range cert.Usages {
+		switch usage {
+		case "server":
+			template.ExtKeyUsage = append(template.ExtKeyUsage, x509.ExtKeyUsageServerAuth)
+		case "client":
+			template.ExtKeyUsage = append(template.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
+		}
+	}
+	parent, signer := template, key
+	if cert.CACert != "" {
+		parent, err = readCertificate(cert.CACert)
+		if err == nil && parent == nil {
+			err = fmt.Errorf("CA certificate %s does not exist", cert.CACert)
+		}
+		if err != nil {
+			return nil, err
+		}
+		signer, err = readPrivateKey(cert.CAKey)
+		if err == nil && signer == nil {
+			err = fmt.Errorf("CA key %s does not exist", cert.CAKey)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	log.Printf("Generating certificate %s for %s", cert.Cert, cert.CommonName)
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), signer)
+	if err != nil {
+		return nil, err
+	}
+	err = writePEM(cert.Cert, &pem.Block{Type: "CERTIFICATE", Bytes: der}, 0644)
+	if err != nil {
+		return nil, err
+	}
+	created, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return certDetails(created), nil
+}
+
+/*
+Write a certificate signing request for the key
+*/
+func (cert *Cert) writeCSR(key crypto.Signer) error {
+	template := &x509.CertificateRequest{
+		Subject:     cert.subject(),
+		DNSNames:    cert.DNSNames,
+		IPAddresses: cert.ipAddresses(),
+	}
+	log.Printf("Generating certificate signing request %s for %s", cert.CSR, cert.CommonName)
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		return err
+	}
+	return writePEM(cert.CSR, &pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}, 0644)
+}
+
+func certDetails(certificate *x509.Certificate) map[string]string {
+	return map[string]string{
+		"subject": certificate.Subject.String(),
+		"issuer":  certificate.Issuer.String(),
+		"expiry":  certificate.NotAfter.UTC().Format(time.RFC3339),
+	}
+}
+
+func samePublicKey(public crypto.PublicKey, key crypto.Signer) bool {
+	if k, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool }); ok {
+		return k.Equal(public)
+	}
+	return false
+}
+
+func keyType(key crypto.Signer) string {
+	switch key.(type) {
+	case *ecdsa.PrivateKey:
+		return "ecdsa"
+	case *rsa.PrivateKey:
+		return "rsa"
+	}
+	return ""
+}
+
+/*
+Read a PEM encoded private key, a missing key is nil
+*/
+func readPrivateKey(path string) (crypto.Signer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s does not contain a PEM encoded key", path)
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+	}
+	return nil, fmt.Errorf("Unsupported private key in %s: %s", path, block.Type)
+}
+
+/*
+Read a PEM encoded certificate, a missing certificate is nil
+*/
+func readCertificate(path string) (*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s does not contain a PEM encoded certificate", path)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func writePEM(path string, block *pem.Block, mode os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, pem.EncodeToMemory(block), mode)
+}
+
+/*
+Compare two lists of strings ignoring their order
+*/
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	sortedA := append([]string{}, a...)
+	sortedB := append([]string{}, b...)
+	sort.Strings(sortedA)
+	sort.Strings(sortedB)
+	return strings.Join(sortedA, "\x00") == strings.Join(sortedB, "\x00")
+}
@@ -0,0 +1,82 @@
+package state
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func certSetup(name, data string, t *testing.T) State {
+	return stateSetup(Metadata{Name: name, Type: "cert", State: "present"}, []byte(data), t)
+}
+
+func TestCertCASigned(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "otter-cert")
+	defer os.RemoveAll(dir)
+	ca := certSetup(filepath.Join(dir, "ca.pem"), fmt.Sprintf(`{"key": "%s/ca-key.pem", "common-name": "otter-ca", "key-type": "ecdsa", "ca": true}`, dir), t)
+	if result := ca.Apply(); !result.Consistent || result.Details["subject"] != "CN=otter-ca" {
+		fmt.Println("Failed to create CA: ", result.Message, result.Details)
+		t.Fail()
+	}
+	data := fmt.Sprintf(`{"key": "%[1]s/docker-key.pem", "common-name": "node1", "dns-names": ["node1.cluster.local"], "ip-addresses": ["10.0.0.5"], "usages": ["server"], "key-type": "ecdsa", "ca-cert": "%[1]s/ca.pem", "ca-key": "%[1]s/ca-key.pem"}`, dir)
+	state := certSetup(filepath.Join(dir, "docker.pem"), data, t)
+	if state.State().Consistent {
+		fmt.Println("Certificate should not exist")
+		t.Fail()
+	}
+	result := state.Apply()
+	if !result.Consistent || result.Details["issuer"] != "CN=otter-ca" || result.Details["expiry"] == "" {
+		fmt.Println("Failed to create certificate: ", result.Message, result.Details)
+		t.Fail()
+	}
+	if result = state.State(); !result.Consistent || result.Details["subject"] != "CN=node1" {
+		fmt.Println("Certificate should be consistent: ", result.Message)
+		t.Fail()
+	}
+	if info, _ := os.Stat(filepath.Join(dir, "docker-key.pem")); info == nil || info.Mode().Perm() != 0600 {
+		fmt.Println("Private key should only be readable by its owner")
+		t.Fail()
+	}
+	// A new CA invalidates the certificate
+	os.Remove(filepath.Join(dir, "ca-key.pem"))
+	ca.Apply()
+	if state.State().Consistent {
+		fmt.Println("Certificate signed by an old CA should not be consistent")
+		t.Fail()
+	}
+}
+
+func TestCertRenewal(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "otter-cert")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "kubelet.pem")
+	certSetup(path, fmt.Sprintf(`{"key": "%s/kubelet-key.pem", "common-name": "kubelet", "key-type": "ecdsa", "days": 10, "renew": 5}`, dir), t).Apply()
+	before, _ := ioutil.ReadFile(path)
+	state := certSetup(path, fmt.Sprintf(`{"key": "%s/kubelet-key.pem", "common-name": "kubelet", "key-type": "ecdsa", "days": 30, "renew": 20}`, dir), t)
+	if result := state.State(); result.Consistent {
+		fmt.Println("Certificate within the renewal window should not be consistent")
+		t.Fail()
+	}
+	state.Apply()
+	after, _ := ioutil.ReadFile(path)
+	if string(before) == string(after) || !state.State().Consistent {
+		fmt.Println("Certificate was not renewed")
+		t.Fail()
+	}
+}
+
+func TestCertCSR(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "otter-cert")
+	defer os.RemoveAll(dir)
+	state := certSetup("etcd", fmt.Sprintf(`{"key": "%[1]s/etcd-key.pem", "csr": "%[1]s/etcd.csr", "common-name": "etcd", "organization": ["otter"], "key-type": "ecdsa"}`, dir), t)
+	if !state.Apply().Consistent || !state.State().Consistent {
+		fmt.Println("Failed to create CSR")
+		t.Fail()
+	}
+	if _, err := os.Stat(filepath.Join(dir, "etcd")); !os.IsNotExist(err) {
+		fmt.Println("A certificate should not be written with a CSR")
+		t.Fail()
+	}
+}
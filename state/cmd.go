@@ -0,0 +1,200 @@
+/*
+A Command represents an arbitrary command executed on an operating system.
+Commands are made idempotent with the creates, unless and onlyif guards, a command without guards always runs.
+States -
+  run: The command has been executed or a guard reports it does not need to run
+*/
+
+package state
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"os"
+	"os/exec"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type Command struct {
+	Command  string            `json:"command"` // Command to execute, defaults to the state name
+	Args     []string          `json:"args"`    // Arguments passed to the command, without arguments the command is run by /bin/sh
+	Cwd      string            `json:"cwd"`     // Working directory of the command
+	Env      map[string]string `json:"env"`     // Additional environment variables
+	User     string            `json:"user"`    // Run the command as this user
+	Timeout  string            `json:"timeout"` // Maximum duration of the command, "30s", "5m", etc.
+	Creates  string            `json:"creates"` // Do not run the command if this path exists
+	Unless   string            `json:"unless"`  // Do not run the command if this shell command succeeds
+	OnlyIf   string            `json:"onlyif"`  // Only run the command if this shell command succeeds
+	Metadata Metadata          `json:"metadata"`
+	timeout  time.Duration
+}
+
+func (command *Command) Meta() Metadata {
+	return command.Metadata
+}
+
+func (command *Command) State() *Result {
+	result := &Result{
+		Metadata:   &command.Metadata,
+		Consistent: false,
+	}
+	if command.Creates != "" {
+		if _, err := os.Stat(command.Creates); err == nil {
+			result.Message = fmt.Sprintf("%s exists", command.Creates)
+			result.Consistent = true
+			return result
+		}
+	}
+	if command.Unless != "" {
+		if code, _, _, err := command.run("/bin/sh", "-c", command.Unless); err == nil && code == 0 {
+			result.Message = fmt.Sprintf("unless condition succeeded: %s", command.Unless)
+			result.Consistent = true
+			return result
+		}
+	}
+	if command.OnlyIf != "" {
+		if code, _, _, err := command.run("/bin/sh", "-c", command.OnlyIf); err != nil || code != 0 {
+			result.Message = fmt.Sprintf("onlyif condition failed: %s", command.OnlyIf)
+			result.Consistent = true
+			return result
+		}
+	}
+	result.Message = fmt.Sprintf("Command %s will run", command.Command)
+	return result
+}
+
+func (command *Command) Apply() *Result {
+	result := command.State()
+	if result.Consistent == true {
+		return result
+	}
+	var (
+		code           int
+		stdout, stderr string
+		err            error
+	)
+	if len(command.Args) > 0 {
+		code, stdout, stderr, err = command.run(command.Command, command.Args...)
+	} else {
+		code, stdout, stderr, err = command.run("/bin/sh", "-c", command.Command)
+	}
+	result.Details = map[string]string{
+		"stdout":    stdout,
+		"stderr":    stderr,
+		"exit_code": strconv.Itoa(code),
+	}
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	if code != 0 {
+		result.Message = fmt.Sprintf("Command exited with status %d: %s", code, strings.TrimSpace(stderr))
+		return result
+	}
+	result.Message = "Command succeeded"
+	result.Consistent = true
+	return result
+}
+
+/*
+Create and validate a new Command State
+*/
+func newCommand(metadata Metadata, data []byte) (*Command, error) {
+	command := &Command{}
+	err := json.Unmarshal(data, &command)
+	if err != nil {
+		return nil, err
+	}
+	command.Metadata = metadata
+	switch metadata.State {
+	case "run":
+	default:
+		return nil, fmt.Errorf("Invalid cmd state: %s", metadata.State)
+	}
+	if command.Command == "" {
+		command.Command = metadata.Name
+	}
+	if command.Timeout != "" {
+		command.timeout, err = time.ParseDuration(command.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid timeout for command %s: %s", command.Command, err)
+		}
+	}
+	return command, nil
+}
+
+/*
+Run a program with the command's working directory, environment, user and timeout.
+The exit code is -1 if the program could not be started or did not exit.
+*/
+func (command *Command) run(name string, args ...string) (int, string, string, error) {
+	ctx := context.Background()
+	if command.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, command.timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = command.Cwd
+	cmd.Env = os.Environ()
+	keys := make([]string, 0)
+	for key := range command.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, command.Env[key]))
+	}
+	if command.User != "" {
+		credential, home, err := lookupCredential(command.User)
+		if err != nil {
+			return -1, "", "", err
+		}
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: credential}
+		cmd.Env = append(cmd.Env, "HOME="+home, "USER="+command.User)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	log.Printf("Running command: %s %s", name, strings.Join(args, " "))
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return -1, stdout.String(), stderr.String(), fmt.Errorf("Command timed out after %s", command.timeout)
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			return status.ExitStatus(), stdout.String(), stderr.String(), nil
+		}
+	}
+	if err != nil {
+		return -1, stdout.String(), stderr.String(), err
+	}
+	return 0, stdout.String(), stderr.String(), nil
+}
+
+/*
+Look up the credentials and home directory of a user
+*/
+func lookupCredential(name string) (*syscall.Credential, string, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, "", err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, "", err
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, "", err
+	}
+	return &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}, u.HomeDir, nil
+}
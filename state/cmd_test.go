@@ -0,0 +1,81 @@
+package state
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var cmdMeta = Metadata{
+	Name:  "echo hello",
+	Type:  "cmd",
+	State: "run",
+}
+
+func TestCommandOutput(t *testing.T) {
+	state := stateSetup(cmdMeta, []byte(`{"env": {"GREETING": "world"}, "command": "echo hello $GREETING; echo oops >&2"}`), t)
+	result := state.Apply()
+	if !result.Consistent {
+		fmt.Println("Command failed: ", result.Message)
+		t.Fail()
+	}
+	if result.Details["stdout"] != "hello world\n" || result.Details["stderr"] != "oops\n" || result.Details["exit_code"] != "0" {
+		fmt.Println("Bad command output: ", result.Details)
+		t.Fail()
+	}
+}
+
+func TestCommandExitCode(t *testing.T) {
+	state := stateSetup(cmdMeta, []byte(`{"command": "false", "args": ["--ignored"]}`), t)
+	result := state.Apply()
+	if result.Consistent || result.Details["exit_code"] != "1" {
+		fmt.Println("Failed to detect command failure: ", result.Details)
+		t.Fail()
+	}
+}
+
+func TestCommandCreates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "otter-cmd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "created")
+	state := stateSetup(cmdMeta, []byte(fmt.Sprintf(`{"command": "touch", "args": ["created"], "cwd": "%s", "creates": "%s"}`, dir, path)), t)
+	if state.State().Consistent {
+		fmt.Println("Command did not run before creating its file")
+		t.Fail()
+	}
+	state.Apply()
+	if !state.State().Consistent {
+		fmt.Println("Command would run after creating its file")
+		t.Fail()
+	}
+}
+
+func TestCommandGuards(t *testing.T) {
+	guards := map[string]bool{
+		`{"unless": "true"}`:  true,
+		`{"unless": "false"}`: false,
+		`{"onlyif": "true"}`:  false,
+		`{"onlyif": "false"}`: true,
+	}
+	for data, consistent := range guards {
+		state := stateSetup(cmdMeta, []byte(data), t)
+		if state.State().Consistent != consistent {
+			fmt.Println("Bad guard evaluation: ", data)
+			t.Fail()
+		}
+	}
+}
+
+func TestCommandTimeout(t *testing.T) {
+	state := stateSetup(cmdMeta, []byte(`{"command": "sleep", "args": ["5"], "timeout": "100ms"}`), t)
+	result := state.Apply()
+	if result.Consistent || result.Details["exit_code"] != "-1" {
+		fmt.Println("Failed to time out command: ", result.Message)
+		t.Fail()
+	}
+}
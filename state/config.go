@@ -0,0 +1,627 @@
+/*
+A Config represents individual keys within an INI, JSON or YAML configuration file.
+Keys are addressed by a dotted path, "section.key" for INI files and "parent.child.key" for JSON and YAML files,
+a literal dot within a key is escaped with a backslash. Keys which are not declared are left untouched.
+States -
+  set: The declared keys are set to their values and the removed keys are absent
+*/
+
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/vektorlab/otter/helpers"
+	"gopkg.in/ini.v1"
+	"gopkg.in/yaml.v3"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type Config struct {
+	Path     string                 `json:"path"`   // Configuration file to edit, defaults to the state name
+	Format   string                 `json:"format"` // "ini", "json" or "yaml", inferred from the file extension when empty
+	Values   map[string]interface{} `json:"values"` // Key paths and the values they are set to
+	Remove   []string               `json:"remove"` // Key paths which are removed
+	Metadata Metadata               `json:"metadata"`
+}
+
+func (config *Config) Meta() Metadata {
+	return config.Metadata
+}
+
+func (config *Config) State() *Result {
+	result := &Result{
+		Metadata:   &config.Metadata,
+		Consistent: false,
+	}
+	original, err := readManagedFile(config.Path, true)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	edited, changed, err := config.edit([]byte(original))
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	if len(changed) > 0 {
+		result.Message = fmt.Sprintf("Keys differ in %s: %s", config.Path, strings.Join(changed, ", "))
+		result.Details = map[string]string{"diff": helpers.Diff(original, string(edited))}
+		return result
+	}
+	result.Consistent = true
+	return result
+}
+
+func (config *Config) Apply() *Result {
+	result := config.State()
+	if result.Consistent == true {
+		return result
+	}
+	original, err := readManagedFile(config.Path, true)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	edited, _, err := config.edit([]byte(original))
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	err = os.MkdirAll(filepath.Dir(config.Path), 0755)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	err = writeManagedFile(config.Path, string(edited))
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	result.Message = fmt.Sprintf("%s updated", config.Path)
+	result.Consistent = true
+	return result
+}
+
+/*
+Create and validate a new Config State
+*/
+func newConfig(metadata Metadata, data []byte) (*Config, error) {
+	config := &Config{}
+	err := json.Unmarshal(data, &config)
+	if err != nil {
+		return nil, err
+	}
+	config.Metadata = metadata
+	for key, value := range config.Values {
+		config.Values[key] = integralNumbers(value)
+	}
+	switch metadata.State {
+	case "set":
+	default:
+		return nil, fmt.Errorf("Invalid config state: %s", metadata.State)
+	}
+	if config.Path == "" {
+		config.Path = metadata.Name
+	}
+	if config.Format == "" {
+		switch strings.ToLower(filepath.Ext(config.Path)) {
+		case ".ini", ".conf", ".cfg":
+			config.Format = "ini"
+		case ".json":
+			config.Format = "json"
+		case ".yaml", ".yml":
+			config.Format = "yaml"
+		default:
+			return nil, fmt.Errorf("Unable to infer the format of %s", config.Path)
+		}
+	}
+	switch config.Format {
+	case "ini", "json", "yaml":
+	default:
+		return nil, fmt.Errorf("Unsupported config format: %s", config.Format)
+	}
+	for _, key := range append(config.keys(), config.Remove...) {
+		if len(splitKeyPath(key)) == 0 {
+			return nil, fmt.Errorf("Invalid key path in %s: %q", config.Path, key)
+		}
+	}
+	return config, nil
+}
+
+/*
+Convert whole numbers decoded from JSON as float64 to int64 so they are not written in exponent form, e.g. 1e+06
+*/
+func integralNumbers(value interface{}) interface{} {
+	switch value := value.(type) {
+	case float64:
+		if value == math.Trunc(value) && math.Abs(value) < 1<<63 {
+			return int64(value)
+		}
+	case map[string]interface{}:
+		for key, child := range value {
+			value[key] = integralNumbers(child)
+		}
+	case []interface{}:
+		for i, child := range value {
+			value[i] = integralNumbers(child)
+		}
+	}
+	return value
+}
+
+/*
+Return the declared key paths in a stable order
+*/
+func (config *Config) keys() []string {
+	keys := make([]string, 0)
+	for key := range config.Values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+/*
+Apply the declared keys to the contents of a configuration file, returning the new contents and the changed key paths
+*/
+func (config *Config) edit(data []byte) ([]byte, []string, error) {
+	switch config.Format {
+	case "ini":
+		return config.editIni(data)
+	case "json":
+		return config.editJson(data)
+	default:
+		return config.editYaml(data)
+	}
+}
+
+/*
+Edit an INI file, the first element of a key path is the section and keys without a section are in the default section
+*/
+func (config *Config) editIni(data []byte) ([]byte, []string, error) {
+	file, err := ini.Load(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to parse %s: %s", config.Path, err)
+	}
+	changed := make([]string, 0)
+	for _, path := range config.keys() {
+		section, key := iniSectionKey(path)
+		value := fmt.Sprint(config.Values[path])
+		s := file.Section(section)
+		if !s.HasKey(key) || s.Key(key).String() != value {
+			s.Key(key).SetValue(value)
+			changed = append(changed, path)
+		}
+	}
+	for _, path := range config.Remove {
+		section, key := iniSectionKey(path)
+		if s, err := file.GetSection(section); err == nil && s.HasKey(key) {
+			s.DeleteKey(key)
+			changed = append(changed, path)
+		}
+	}
+	if len(changed) == 0 {
+		return data, changed, nil
+	}
+	var buf bytes.Buffer
+	_, err = file.WriteTo(&buf)
+	return buf.Bytes(), changed, err
+}
+
+/*
+Edit a JSON file, the order of existing keys is preserved
+*/
+func (config *Config) editJson(data []byte) ([]byte, []string, error) {
+	var document interface{} = jsonObject{}
+	if len(bytes.TrimSpace(data)) > 0 {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		var err error
+		document, err = decodeOrderedJson(decoder)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Unable to parse %s: %s", config.Path, err)
+		}
+	}
+	root, ok := document.(jsonObject)
+	if !ok {
+		return nil, nil, fmt.Errorf("The root of %s is not an object", config.Path)
+	}
+	changed := make([]string, 0)
+	for _, path := range config.keys() {
+		updated, didChange, err := root.set(splitKeyPath(path), config.Values[path])
+		if err != nil {
+			return nil, nil, fmt.Errorf("Unable to set %s in %s: %s", path, config.Path, err)
+		}
+		root = updated
+		if didChange {
+			changed = append(changed, path)
+		}
+	}
+	for _, path := range config.Remove {
+		updated, didChange := root.remove(splitKeyPath(path))
+		root = updated
+		if didChange {
+			changed = append(changed, path)
+		}
+	}
+	if len(changed) == 0 {
+		return data, changed, nil
+	}
+	compact, err := marshalOrderedJson(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	var out bytes.Buffer
+	err = json.Indent(&out, compact, "", detectIndent(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	out.WriteString("\n")
+	return out.Bytes(), changed, nil
+}
+
+/*
+Edit a YAML file, comments and the order of existing keys are preserved
+*/
+func (config *Config) editYaml(data []byte) ([]byte, []string, error) {
+	document := &yaml.Node{Kind: yaml.DocumentNode}
+	if len(bytes.TrimSpace(data)) > 0 {
+		err := yaml.Unmarshal(data, document)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Unable to parse %s: %s", config.Path, err)
+		}
+	}
+	if len(document.Content) == 0 {
+		document.Content = []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}
+	}
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, nil, fmt.Errorf("The root of %s is not a mapping", config.Path)
+	}
+	changed := make([]string, 0)
+	for _, path := range config.keys() {
+		didChange, err := setYamlKey(root, splitKeyPath(path), config.Values[path])
+		if err != nil {
+			return nil, nil, fmt.Errorf("Unable to set %s in %s: %s", path, config.Path, err)
+		}
+		if didChange {
+			changed = append(changed, path)
+		}
+	}
+	for _, path := range config.Remove {
+		if removeYamlKey(root, splitKeyPath(path)) {
+			changed = append(changed, path)
+		}
+	}
+	if len(changed) == 0 {
+		return data, changed, nil
+	}
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	err := encoder.Encode(document)
+	if err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), changed, encoder.Close()
+}
+
+/*
+Split a dotted key path, a backslash escapes a literal dot
+*/
+func splitKeyPath(path string) []string {
+	keys := make([]string, 0)
+	var current bytes.Buffer
+	escaped := false
+	for _, c := range path {
+		switch {
+		case escaped:
+			current.WriteRune(c)
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '.':
+			keys = append(keys, current.String())
+			current.Reset()
+		default:
+			current.WriteRune(c)
+		}
+	}
+	keys = append(keys, current.String())
+	for _, key := range keys {
+		if key == "" {
+			return []string{}
+		}
+	}
+	return keys
+}
+
+func iniSectionKey(path string) (string, string) {
+	keys := splitKeyPath(path)
+	if len(keys) == 1 {
+		return ini.DefaultSection, keys[0]
+	}
+	return keys[0], strings.Join(keys[1:], ".")
+}
+
+/*
+Use the indentation of the first indented line in a document, defaulting to two spaces
+*/
+func detectIndent(data []byte) string {
+	for _, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && len(trimmed) < len(line) {
+			return line[:len(line)-len(trimmed)]
+		}
+	}
+	return "  "
+}
+
+/*
+Compare two values by their canonical JSON encoding so numbers, maps and ordered objects compare by content
+*/
+func sameJsonValue(a, b interface{}) bool {
+	canonical := func(v interface{}) string {
+		data, err := marshalOrderedJson(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		var decoded interface{}
+		json.Unmarshal(data, &decoded)
+		data, _ = json.Marshal(decoded)
+		return string(data)
+	}
+	return canonical(a) == canonical(b)
+}
+
+/*
+A JSON object which keeps the order of its members
+*/
+type jsonObject []jsonMember
+
+type jsonMember struct {
+	Key   string
+	Value interface{}
+}
+
+/*
+Set the value at a key path, creating intermediate objects as needed
+*/
+func (object jsonObject) set(keys []string, value interface{}) (jsonObject, bool, error) {
+	for i, member := range object {
+		if member.Key != keys[0] {
+			continue
+		}
+		if len(keys) == 1 {
+			if sameJsonValue(member.Value, value) {
+				return object, false, nil
+			}
+			object[i].Value = value
+			return object, true, nil
+		}
+		child, ok := member.Value.(jsonObject)
+		if !ok {
+			return object, false, fmt.Errorf("%s is not an object", keys[0])
+		}
+		updated, changed, err := child.set(keys[1:], value)
+		object[i].Value = updated
+		return object, changed, err
+	}
+	if len(keys) == 1 {
+		return append(object, jsonMember{Key: keys[0], Value: value}), true, nil
+	}
+	child, _, err := jsonObject{}.set(keys[1:], value)
+	return append(object, jsonMember{Key: keys[0], Value: child}), true, err
+}
+
+/*
+Remove the value at a key path
+*/
+func (object jsonObject) remove(keys []string) (jsonObject, bool) {
+	for i, member := range object {
+		if member.Key != keys[0] {
+			continue
+		}
+		if len(keys) == 1 {
+			return append(object[:i:i], object[i+1:]...), true
+		}
+		child, ok := member.Value.(jsonObject)
+		if !ok {
+			return object, false
+		}
+		updated, changed := child.remove(keys[1:])
+		object[i].Value = updated
+		return object, changed
+	}
+	return object, false
+}
+
+/*
+Encode a JSON value without reordering object members or escaping HTML characters
+*/
+func marshalOrderedJson(value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	switch v := value.(type) {
+	case jsonObject:
+		buf.WriteString("{")
+		for i, member := range v {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			key, err := marshalOrderedJson(member.Key)
+			if err != nil {
+				return nil, err
+			}
+			data, err := marshalOrderedJson(member.Value)
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(key)
+			buf.WriteString(":")
+			buf.Write(data)
+		}
+		buf.WriteString("}")
+	case map[string]interface{}:
+		keys := make([]string, 0)
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		object := jsonObject{}
+		for _, key := range keys {
+			object = append(object, jsonMember{Key: key, Value: v[key]})
+		}
+		return marshalOrderedJson(object)
+	case []interface{}:
+		buf.WriteString("[")
+		for i, element := range v {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			data, err := marshalOrderedJson(element)
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(data)
+		}
+		buf.WriteString("]")
+	default:
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		err := encoder.Encode(v)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.TrimRight(buf.Bytes(), "\n"), nil
+	}
+	return buf.Bytes(), nil
+}
+
+/*
+Decode a JSON value keeping the order of object members
+*/
+func decodeOrderedJson(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	value, err := decodeOrderedJsonToken(decoder, token)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("Unexpected data after JSON document")
+	}
+	return value, nil
+}
+
+func decodeOrderedJsonToken(decoder *json.Decoder, token json.Token) (interface{}, error) {
+	switch token {
+	case json.Delim('{'):
+		object := jsonObject{}
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			next, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrderedJsonToken(decoder, next)
+			if err != nil {
+				return nil, err
+			}
+			object = append(object, jsonMember{Key: key.(string), Value: value})
+		}
+		_, err := decoder.Token() // Closing brace
+		return object, err
+	case json.Delim('['):
+		array := make([]interface{}, 0)
+		for decoder.More() {
+			next, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrderedJsonToken(decoder, next)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		_, err := decoder.Token() // Closing bracket
+		return array, err
+	default:
+		return token, nil
+	}
+}
+
+/*
+Set the value at a key path within a YAML mapping, creating intermediate mappings as needed
+*/
+func setYamlKey(mapping *yaml.Node, keys []string, value interface{}) (bool, error) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value != keys[0] {
+			continue
+		}
+		current := mapping.Content[i+1]
+		if len(keys) > 1 {
+			if current.Kind != yaml.MappingNode {
+				return false, fmt.Errorf("%s is not a mapping", keys[0])
+			}
+			return setYamlKey(current, keys[1:], value)
+		}
+		var decoded interface{}
+		if err := current.Decode(&decoded); err == nil && sameJsonValue(decoded, value) {
+			return false, nil
+		}
+		replacement := &yaml.Node{}
+		if err := replacement.Encode(value); err != nil {
+			return false, err
+		}
+		replacement.HeadComment = current.HeadComment
+		replacement.LineComment = current.LineComment
+		replacement.FootComment = current.FootComment
+		mapping.Content[i+1] = replacement
+		return true, nil
+	}
+	key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: keys[0]}
+	if len(keys) > 1 {
+		child := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		mapping.Content = append(mapping.Content, key, child)
+		return setYamlKey(child, keys[1:], value)
+	}
+	node := &yaml.Node{}
+	if err := node.Encode(value); err != nil {
+		return false, err
+	}
+	mapping.Content = append(mapping.Content, key, node)
+	return true, nil
+}
+
+/*
+Remove the value at a key path within a YAML mapping
+*/
+func removeYamlKey(mapping *yaml.Node, keys []string) bool {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value != keys[0] {
+			continue
+		}
+		if len(keys) > 1 {
+			if mapping.Content[i+1].Kind != yaml.MappingNode {
+				return false
+			}
+			return removeYamlKey(mapping.Content[i+1], keys[1:])
+		}
+		mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+package state
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var containerdIni = `root = /var/lib/containerd
+
+; grpc settings
+[grpc]
+address = /run/containerd/containerd.sock
+uid = 0
+`
+
+var kubeletJson = `{
+    "kind": "KubeletConfiguration",
+    "evictionHard": {
+        "memory.available": "100Mi"
+    },
+    "failSwapOn": true
+}
+`
+
+var kubeletYaml = `# managed by kubeadm
+kind: KubeletConfiguration
+evictionHard:
+  memory.available: 100Mi # default
+failSwapOn: true
+`
+
+func configSetup(name, content, data string, t *testing.T) (State, string) {
+	dir, err := ioutil.TempDir("", "otter-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if content != "" {
+		ioutil.WriteFile(path, []byte(content), 0644)
+	}
+	metadata := Metadata{Name: path, Type: "config", State: "set"}
+	return stateSetup(metadata, []byte(data), t), path
+}
+
+func TestSplitKeyPath(t *testing.T) {
+	keys := splitKeyPath(`evictionHard.memory\.available`)
+	if len(keys) != 2 || keys[1] != "memory.available" {
+		fmt.Println("Bad key path: ", keys)
+		t.Fail()
+	}
+	if len(splitKeyPath("a..b")) != 0 {
+		fmt.Println("Accepted key path with an empty key")
+		t.Fail()
+	}
+}
+
+func TestConfigIni(t *testing.T) {
+	state, path := configSetup("config.ini", containerdIni, `{"format": "ini", "values": {"grpc.uid": 1000, "debug.level": "info"}, "remove": ["root"]}`, t)
+	defer os.RemoveAll(filepath.Dir(path))
+	result := state.Apply()
+	if !result.Consistent {
+		fmt.Println("Failed to set INI keys: ", result.Message)
+		t.FailNow()
+	}
+	data, _ := ioutil.ReadFile(path)
+	content := string(data)
+	if !strings.Contains(content, "; grpc settings") || strings.Contains(content, "/var/lib/containerd") ||
+		!strings.Contains(content, "1000") || !strings.Contains(content, "[debug]") {
+		fmt.Println("Bad INI file: ", content)
+		t.Fail()
+	}
+	if !state.State().Consistent {
+		fmt.Println("INI keys not consistent after apply")
+		t.Fail()
+	}
+}
+
+func TestConfigJson(t *testing.T) {
+	state, path := configSetup("kubelet.json", kubeletJson, `{"values": {"evictionHard.memory\\.available": "200Mi", "cgroupDriver": "systemd"}}`, t)
+	defer os.RemoveAll(filepath.Dir(path))
+	result := state.State()
+	if result.Consistent || result.Message != fmt.Sprintf("Keys differ in %s: cgroupDriver, evictionHard.memory\\.available", path) {
+		fmt.Println("Failed to detect changed JSON keys: ", result.Message)
+		t.Fail()
+	}
+	state.Apply()
+	data, _ := ioutil.ReadFile(path)
+	expected := `{
+    "kind": "KubeletConfiguration",
+    "evictionHard": {
+        "memory.available": "200Mi"
+    },
+    "failSwapOn": true,
+    "cgroupDriver": "systemd"
+}
+`
+	if string(data) != expected {
+		fmt.Println("Bad JSON file: ", string(data))
+		t.Fail()
+	}
+}
+
+func TestConfigYaml(t *testing.T) {
+	state, path := configSetup("kubelet.yaml", kubeletYaml, `{"values": {"failSwapOn": false, "authentication.anonymous.enabled": false}}`, t)
+	defer os.RemoveAll(filepath.Dir(path))
+	state.Apply()
+	data, _ := ioutil.ReadFile(path)
+	expected := `# managed by kubeadm
+kind: KubeletConfiguration
+evictionHard:
+  memory.available: 100Mi # default
+failSwapOn: false
+authentication:
+  anonymous:
+    enabled: false
+`
+	if string(data) != expected {
+		fmt.Println("Bad YAML file: ", string(data))
+		t.Fail()
+	}
+	if !state.State().Consistent {
+		fmt.Println("YAML keys not consistent after apply")
+		t.Fail()
+	}
+}
+
+func TestConfigUnchanged(t *testing.T) {
+	state, path := configSetup("kubelet.json", kubeletJson, `{"values": {"failSwapOn": true, "evictionHard": {"memory.available": "100Mi"}}}`, t)
+	defer os.RemoveAll(filepath.Dir(path))
+	if !state.State().Consistent {
+		fmt.Println("Detected change in consistent JSON file")
+		t.Fail()
+	}
+}
+
+func TestConfigLargeInteger(t *testing.T) {
+	for name, expected := range map[string]string{
+		"limits.ini":  "max_connections = 1000000",
+		"limits.yaml": "max_connections: 1000000",
+	} {
+		state, path := configSetup(name, "", `{"values": {"limits.max_connections": 1000000}}`, t)
+		defer os.RemoveAll(filepath.Dir(path))
+		state.Apply()
+		data, _ := ioutil.ReadFile(path)
+		if !strings.Contains(string(data), expected) {
+			fmt.Println("Large integer was not written in full: ", string(data))
+			t.Fail()
+		}
+		if !state.State().Consistent {
+			fmt.Println("Large integer not consistent after apply: ", path)
+			t.Fail()
+		}
+	}
+}
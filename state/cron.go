@@ -0,0 +1,224 @@
+/*
+A Cron represents a scheduled job in a user crontab or in a file within /etc/cron.d.
+Managed entries are preceded by a "# otter: <name>" comment, lines without the marker are never modified.
+States -
+  present: The job is scheduled with the declared schedule and command
+  absent: The job is not scheduled
+*/
+
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/vektorlab/otter/helpers"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var cronDPath = "/etc/cron.d"
+
+// cron ignores files in /etc/cron.d with names containing other characters
+var cronFilePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+var cronSpecials = map[string]bool{
+	"@reboot": true, "@yearly": true, "@annually": true, "@monthly": true,
+	"@weekly": true, "@daily": true, "@midnight": true, "@hourly": true,
+}
+
+type Cron struct {
+	Job      string   `json:"job"`     // Command run by the job
+	User     string   `json:"user"`    // User the job runs as, defaults to root
+	Minute   string   `json:"minute"`  // Minute of the schedule, defaults to "*"
+	Hour     string   `json:"hour"`    // Hour of the schedule, defaults to "*"
+	Day      string   `json:"day"`     // Day of the month of the schedule, defaults to "*"
+	Month    string   `json:"month"`   // Month of the schedule, defaults to "*"
+	Weekday  string   `json:"weekday"` // Day of the week of the schedule, defaults to "*"
+	Special  string   `json:"special"` // Special schedule such as "@daily", replaces the schedule fields
+	File     string   `json:"file"`    // Name of a file in /etc/cron.d, the user crontab is managed when empty
+	Metadata Metadata `json:"metadata"`
+}
+
+func (cron *Cron) Meta() Metadata {
+	return cron.Metadata
+}
+
+func (cron *Cron) State() *Result {
+	result := &Result{
+		Metadata:   &cron.Metadata,
+		Consistent: false,
+	}
+	content, err := cron.read()
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	if diff := helpers.Diff(content, cron.edit(content)); diff != "" {
+		result.Message = fmt.Sprintf("%s would change", cron.location())
+		result.Details = map[string]string{"diff": diff}
+		return result
+	}
+	result.Consistent = true
+	return result
+}
+
+func (cron *Cron) Apply() *Result {
+	result := cron.State()
+	if result.Consistent == true {
+		return result
+	}
+	content, err := cron.read()
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	err = cron.write(cron.edit(content))
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	result.Message = fmt.Sprintf("%s updated", cron.location())
+	result.Consistent = true
+	return result
+}
+
+/*
+Create and validate a new Cron State
+*/
+func newCron(metadata Metadata, data []byte) (*Cron, error) {
+	cron := &Cron{}
+	err := json.Unmarshal(data, &cron)
+	if err != nil {
+		return nil, err
+	}
+	cron.Metadata = metadata
+	switch metadata.State {
+	case "present":
+		if cron.Job == "" {
+			return nil, fmt.Errorf("No job specified for cron %s", metadata.Name)
+		}
+	case "absent":
+	default:
+		return nil, fmt.Errorf("Invalid cron state: %s", metadata.State)
+	}
+	if strings.Contains(metadata.Name, "\n") || strings.Contains(cron.Job, "\n") {
+		return nil, fmt.Errorf("Cron %s must not contain a newline", metadata.Name)
+	}
+	if cron.User == "" {
+		cron.User = "root"
+	}
+	if cron.File != "" && !strings.Contains(cron.File, "/") {
+		if !cronFilePattern.MatchString(cron.File) {
+			return nil, fmt.Errorf("Invalid cron file name %s, cron ignores names containing characters other than letters, digits, - and _", cron.File)
+		}
+		cron.File = filepath.Join(cronDPath, cron.File)
+	}
+	if cron.Special != "" {
+		if !cronSpecials[cron.Special] {
+			return nil, fmt.Errorf("Invalid special schedule for cron %s: %s", metadata.Name, cron.Special)
+		}
+		if cron.Minute != "" || cron.Hour != "" || cron.Day != "" || cron.Month != "" || cron.Weekday != "" {
+			return nil, fmt.Errorf("Cron %s may not have both a special schedule and schedule fields", metadata.Name)
+		}
+	}
+	for _, field := range []*string{&cron.Minute, &cron.Hour, &cron.Day, &cron.Month, &cron.Weekday} {
+		if *field == "" {
+			*field = "*"
+		}
+		if strings.ContainsAny(*field, " \t") {
+			return nil, fmt.Errorf("Invalid schedule for cron %s: %q", metadata.Name, *field)
+		}
+	}
+	return cron, nil
+}
+
+func (cron *Cron) marker() string {
+	return "# otter: " + cron.Metadata.Name
+}
+
+/*
+Return the crontab line of the job, lines in /etc/cron.d include the user
+*/
+func (cron *Cron) line() string {
+	schedule := cron.Special
+	if schedule == "" {
+		schedule = strings.Join([]string{cron.Minute, cron.Hour, cron.Day, cron.Month, cron.Weekday}, " ")
+	}
+	if cron.File != "" {
+		return fmt.Sprintf("%s %s %s", schedule, cron.User, cron.Job)
+	}
+	return fmt.Sprintf("%s %s", schedule, cron.Job)
+}
+
+func (cron *Cron) location() string {
+	if cron.File != "" {
+		return cron.File
+	}
+	return fmt.Sprintf("crontab of %s", cron.User)
+}
+
+/*
+Return the content of the crontab with the job applied, the marker and the line following it are the managed entry
+*/
+func (cron *Cron) edit(content string) string {
+	lines := splitFileLines(content)
+	edited := make([]string, 0)
+	found := false
+	for i := 0; i < len(lines); i++ {
+		if lines[i] != cron.marker() {
+			edited = append(edited, lines[i])
+			continue
+		}
+		i++ // Skip the managed line
+		if cron.Metadata.State == "present" && !found {
+			edited = append(edited, cron.marker(), cron.line())
+			found = true
+		}
+	}
+	if cron.Metadata.State == "present" && !found {
+		edited = append(edited, cron.marker(), cron.line())
+	}
+	return joinFileLines(edited)
+}
+
+/*
+Read the crontab, a missing crontab is empty
+*/
+func (cron *Cron) read() (string, error) {
+	if cron.File != "" {
+		return readManagedFile(cron.File, true)
+	}
+	out, err := commandOutput("crontab", "-l", "-u", cron.User)
+	if err != nil {
+		if strings.Contains(err.Error(), "no crontab for") {
+			return "", nil
+		}
+		return "", err
+	}
+	return out, nil
+}
+
+/*
+Write the crontab, user crontabs are installed with crontab so cron is notified of the change
+*/
+func (cron *Cron) write(content string) error {
+	if cron.File != "" {
+		return writeManagedFile(cron.File, content)
+	}
+	f, err := ioutil.TempFile("", "otter-crontab")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return runCommand("crontab", "-u", cron.User, f.Name())
+}
@@ -0,0 +1,78 @@
+package state
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var cronD = `SHELL=/bin/sh
+PATH=/usr/local/sbin:/usr/local/bin:/sbin:/bin:/usr/sbin:/usr/bin
+30 3 * * * root /usr/local/bin/backup
+`
+
+func cronSetup(state, data string, t *testing.T) State {
+	metadata := Metadata{Name: "docker prune", Type: "cron", State: state}
+	return stateSetup(metadata, []byte(data), t)
+}
+
+func TestCronFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "otter-cron")
+	defer os.RemoveAll(dir)
+	oldCronD := cronDPath
+	defer func() { cronDPath = oldCronD }()
+	cronDPath = dir
+	path := filepath.Join(dir, "docker")
+	ioutil.WriteFile(path, []byte(cronD), 0644)
+	state := cronSetup("present", `{"job": "docker system prune -f", "minute": "0", "hour": "4", "file": "docker"}`, t)
+	if result := state.State(); result.Consistent || result.Details["diff"] != "+# otter: docker prune\n+0 4 * * * root docker system prune -f" {
+		fmt.Println("Bad cron diff: ", result.Details["diff"])
+		t.Fail()
+	}
+	state.Apply()
+	if !state.State().Consistent {
+		fmt.Println("Cron job should be present")
+		t.Fail()
+	}
+	state = cronSetup("present", `{"job": "docker system prune -af", "special": "@daily", "file": "docker"}`, t)
+	state.Apply()
+	data, _ := ioutil.ReadFile(path)
+	if string(data) != cronD+"# otter: docker prune\n@daily root docker system prune -af\n" {
+		fmt.Println("Bad cron update: ", string(data))
+		t.Fail()
+	}
+	cronSetup("absent", `{"file": "docker"}`, t).Apply()
+	data, _ = ioutil.ReadFile(path)
+	if string(data) != cronD {
+		fmt.Println("Bad cron removal: ", string(data))
+		t.Fail()
+	}
+}
+
+func TestCronUserLine(t *testing.T) {
+	cron, err := newCron(Metadata{Name: "logrotate", Type: "cron", State: "present"}, []byte(`{"job": "logrotate /etc/logrotate.conf", "user": "ops", "hour": "*/6"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cron.line() != "* */6 * * * logrotate /etc/logrotate.conf" {
+		fmt.Println("Bad crontab line: ", cron.line())
+		t.Fail()
+	}
+}
+
+func TestCronInvalid(t *testing.T) {
+	for _, data := range []string{
+		`{"job": "true", "file": "docker.cron"}`,
+		`{"job": "true", "special": "@fortnightly"}`,
+		`{"job": "true", "special": "@daily", "hour": "1"}`,
+		`{"job": "true", "minute": "0 1"}`,
+		`{}`,
+	} {
+		if _, err := newCron(Metadata{Name: "invalid", Type: "cron", State: "present"}, []byte(data)); err == nil {
+			fmt.Println("Cron should be invalid: ", data)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,237 @@
+/*
+A DockerDaemon represents the configuration of the Docker daemon stored in /etc/docker/daemon.json.
+Only the declared keys are managed, any other keys already present in the file are preserved.
+States -
+  configured: The declared keys are merged into daemon.json
+*/
+
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+type DockerDaemon struct {
+	Path               string   `json:"path"`                // Location of daemon.json
+	Service            string   `json:"service"`             // Service which must be restarted when the configuration changes
+	StorageDriver      string   `json:"storage-driver"`      // Storage driver used by the daemon
+	LogDriver          string   `json:"log-driver"`          // Default logging driver for containers
+	RegistryMirrors    []string `json:"registry-mirrors"`    // Preferred Docker registry mirrors
+	InsecureRegistries []string `json:"insecure-registries"` // Registries which may be accessed without TLS
+	Metadata           Metadata `json:"metadata"`
+}
+
+func (dockerd *DockerDaemon) Meta() Metadata {
+	return dockerd.Metadata
+}
+
+func (dockerd *DockerDaemon) State() *Result {
+	result := &Result{
+		Metadata:   &dockerd.Metadata,
+		Consistent: false,
+	}
+	current, err := dockerd.readConfig()
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	changed := dockerd.changedKeys(current, dockerd.merge(current))
+	if len(changed) > 0 {
+		result.Message = fmt.Sprintf("Keys differ in %s: %s", dockerd.Path, strings.Join(changed, ", "))
+		return result
+	}
+	result.Consistent = true
+	return result
+}
+
+func (dockerd *DockerDaemon) Apply() *Result {
+	result := dockerd.State()
+	if result.Consistent == true {
+		return result
+	}
+	current, err := dockerd.readConfig()
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	merged := dockerd.merge(current)
+	err = validateDockerConfig(merged)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	err = dockerd.writeConfig(merged)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	result.Message = fmt.Sprintf("Docker daemon configured, service %s requires a restart", dockerd.Service)
+	result.Details = map[string]string{"restart": dockerd.Service}
+	result.Consistent = true
+	return result
+}
+
+/*
+Create and validate a new DockerDaemon State
+*/
+func newDockerDaemon(metadata Metadata, data []byte) (*DockerDaemon, error) {
+	dockerd := &DockerDaemon{}
+	err := json.Unmarshal(data, &dockerd)
+	if err != nil {
+		return nil, err
+	}
+	dockerd.Metadata = metadata
+	switch metadata.State {
+	case "configured":
+	default:
+		return nil, fmt.Errorf("Invalid dockerd state: %s", metadata.State)
+	}
+	if dockerd.Path == "" {
+		dockerd.Path = "/etc/docker/daemon.json"
+	}
+	if dockerd.Service == "" {
+		dockerd.Service = "docker"
+	}
+	err = validateDockerConfig(dockerd.merge(map[string]interface{}{}))
+	if err != nil {
+		return nil, err
+	}
+	return dockerd, nil
+}
+
+/*
+Read the current daemon configuration, a missing or empty file is treated as an empty configuration
+*/
+func (dockerd *DockerDaemon) readConfig() (map[string]interface{}, error) {
+	config := make(map[string]interface{})
+	data, err := ioutil.ReadFile(dockerd.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return config, nil
+		}
+		return nil, err
+	}
+	if strings.TrimSpace(string(data)) == "" {
+		return config, nil
+	}
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse %s: %s", dockerd.Path, err)
+	}
+	return config, nil
+}
+
+/*
+Write the daemon configuration to disk, keeping the mode of an existing file
+*/
+func (dockerd *DockerDaemon) writeConfig(config map[string]interface{}) error {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(dockerd.Path); err == nil {
+		mode = info.Mode()
+	}
+	err = os.MkdirAll(filepath.Dir(dockerd.Path), 0755)
+	if err != nil {
+		return err
+	}
+	log.Printf("Writing Docker daemon configuration to %s", dockerd.Path)
+	return ioutil.WriteFile(dockerd.Path, append(data, '\n'), mode)
+}
+
+/*
+Return a copy of the configuration with the declared keys merged in
+*/
+func (dockerd *DockerDaemon) merge(current map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{})
+	for key, value := range current {
+		merged[key] = value
+	}
+	if dockerd.StorageDriver != "" {
+		merged["storage-driver"] = dockerd.StorageDriver
+	}
+	if dockerd.LogDriver != "" {
+		merged["log-driver"] = dockerd.LogDriver
+	}
+	if dockerd.RegistryMirrors != nil {
+		merged["registry-mirrors"] = stringsToInterfaces(dockerd.RegistryMirrors)
+	}
+	if dockerd.InsecureRegistries != nil {
+		merged["insecure-registries"] = stringsToInterfaces(dockerd.InsecureRegistries)
+	}
+	return merged
+}
+
+/*
+List the keys whose effective value differs between two configurations
+*/
+func (dockerd *DockerDaemon) changedKeys(current, merged map[string]interface{}) []string {
+	changed := make([]string, 0)
+	for key, value := range merged {
+		if !reflect.DeepEqual(current[key], value) {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+/*
+Validate the keys managed by Otter in a Docker daemon configuration
+*/
+func validateDockerConfig(config map[string]interface{}) error {
+	for _, key := range []string{"storage-driver", "log-driver"} {
+		if value, ok := config[key]; ok {
+			if s, ok := value.(string); !ok || s == "" {
+				return fmt.Errorf("Invalid value for %s: %v", key, value)
+			}
+		}
+	}
+	for _, key := range []string{"registry-mirrors", "insecure-registries"} {
+		value, ok := config[key]
+		if !ok {
+			continue
+		}
+		entries, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("Invalid value for %s: %v", key, value)
+		}
+		for _, entry := range entries {
+			s, ok := entry.(string)
+			if !ok || s == "" {
+				return fmt.Errorf("Invalid entry in %s: %v", key, entry)
+			}
+			switch key {
+			case "registry-mirrors":
+				u, err := url.Parse(s)
+				if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+					return fmt.Errorf("Registry mirror must be an http or https URL: %s", s)
+				}
+			case "insecure-registries":
+				if strings.Contains(s, "://") {
+					return fmt.Errorf("Insecure registry must not contain a scheme: %s", s)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func stringsToInterfaces(values []string) []interface{} {
+	converted := make([]interface{}, len(values))
+	for i, value := range values {
+		converted[i] = value
+	}
+	return converted
+}
@@ -0,0 +1,81 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var dockerdMeta = Metadata{
+	Name:  "docker-daemon",
+	Type:  "dockerd",
+	State: "configured",
+}
+
+func dockerdSetup(existing string, t *testing.T) (State, string) {
+	dir, err := ioutil.TempDir("", "otter-dockerd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "daemon.json")
+	if existing != "" {
+		err = ioutil.WriteFile(path, []byte(existing), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	data := []byte(fmt.Sprintf(`{
+		"path": "%s",
+		"storage-driver": "overlay2",
+		"registry-mirrors": ["https://mirror.example.com"],
+		"insecure-registries": ["registry.local:5000"]
+	}`, path))
+	return stateSetup(dockerdMeta, data, t), path
+}
+
+func TestDockerdMergePreservesKeys(t *testing.T) {
+	state, path := dockerdSetup(`{"debug": true, "storage-driver": "aufs"}`, t)
+	defer os.RemoveAll(filepath.Dir(path))
+	if state.State().Consistent {
+		fmt.Println("Failed to detect changed storage-driver")
+		t.Fail()
+	}
+	result := state.Apply()
+	if !result.Consistent || result.Details["restart"] != "docker" {
+		fmt.Println("Failed to configure Docker daemon: ", result.Message)
+		t.Fail()
+	}
+	data, _ := ioutil.ReadFile(path)
+	config := make(map[string]interface{})
+	err := json.Unmarshal(data, &config)
+	if err != nil {
+		fmt.Println("Wrote invalid daemon.json: ", err)
+		t.Fail()
+	}
+	if config["debug"] != true || config["storage-driver"] != "overlay2" {
+		fmt.Println("Bad daemon.json merge: ", string(data))
+		t.Fail()
+	}
+}
+
+func TestDockerdNoRestartWhenUnchanged(t *testing.T) {
+	state, path := dockerdSetup("", t)
+	defer os.RemoveAll(filepath.Dir(path))
+	state.Apply()
+	result := state.Apply()
+	if !result.Consistent || result.Details["restart"] != "" {
+		fmt.Println("Restart requested without a configuration change: ", result.Message)
+		t.Fail()
+	}
+}
+
+func TestDockerdInvalidMirror(t *testing.T) {
+	_, err := StateFactory(dockerdMeta, []byte(`{"registry-mirrors": ["mirror.example.com"]}`))
+	if err == nil {
+		fmt.Println("Failed to reject registry mirror without a scheme")
+		t.Fail()
+	}
+}
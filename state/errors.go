@@ -0,0 +1,32 @@
+package state
+
+import (
+	"fmt"
+)
+
+/*
+An UnknownTypeError is returned when a state's type is not one which can be loaded
+*/
+type UnknownTypeError struct {
+	Type       string
+	Suggestion string // The closest known type, empty if none are similar
+}
+
+func (err *UnknownTypeError) Error() string {
+	return fmt.Sprintf("Unknown state type %s%s", err.Type, didYouMean(err.Suggestion))
+}
+
+/*
+A DecodeError is returned when the JSON data of a state or a payload of states can not be decoded
+*/
+type DecodeError struct {
+	Metadata *Metadata // The state being decoded, nil when the payload itself is invalid
+	Err      error
+}
+
+func (err *DecodeError) Error() string {
+	if err.Metadata == nil {
+		return fmt.Sprintf("Unable to decode states: %s", err.Err)
+	}
+	return err.Metadata.Errorf("Unable to decode %s %s.%s: %s", err.Metadata.Name, err.Metadata.Type, err.Metadata.State, err.Err).Error()
+}
@@ -0,0 +1,88 @@
+package state
+
+import (
+	"bytes"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"os/exec"
+	"strings"
+)
+
+/*
+A commandRunner runs commands on the operating system, tests replace it so states can be exercised without root
+*/
+type commandRunner interface {
+	Run(name string, args ...string) error              // Run a command, the output of a failed command is returned with the error
+	Output(name string, args ...string) (string, error) // Run a command and return its standard output
+}
+
+var commander commandRunner = execCommander{}
+
+type execCommander struct{}
+
+func (execCommander) Run(name string, args ...string) error {
+	log.Printf("Running command: %s %s", name, strings.Join(args, " "))
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return newCommandError(name, err, out)
+	}
+	return nil
+}
+
+func (execCommander) Output(name string, args ...string) (string, error) {
+	log.Printf("Running command: %s %s", name, strings.Join(args, " "))
+	var stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", newCommandError(name, err, stderr.Bytes())
+	}
+	return string(out), nil
+}
+
+/*
+A commandError is returned when a command fails, it keeps the exit status so callers can tell failures apart
+*/
+type commandError struct {
+	name   string
+	err    error
+	output string
+	status int // Exit status of the command, -1 if it could not be run
+}
+
+func (err *commandError) Error() string {
+	return fmt.Sprintf("%s failed: %s: %s", err.name, err.err, err.output)
+}
+
+func newCommandError(name string, err error, output []byte) *commandError {
+	status := -1
+	if exit, ok := err.(*exec.ExitError); ok {
+		status = exit.ExitCode()
+	}
+	return &commandError{name: name, err: err, output: strings.TrimSpace(string(output)), status: status}
+}
+
+/*
+Return the exit status of a failed command, -1 if the error is not from a command which ran and exited
+*/
+func exitStatus(err error) int {
+	if err, ok := err.(*commandError); ok {
+		return err.status
+	}
+	return -1
+}
+
+/*
+Run a command on the operating system, the output of a failed command is returned with the error
+*/
+func runCommand(name string, args ...string) error {
+	return commander.Run(name, args...)
+}
+
+/*
+Run a command on the operating system and return its standard output
+*/
+func commandOutput(name string, args ...string) (string, error) {
+	return commander.Output(name, args...)
+}
@@ -2,15 +2,85 @@ package state
 
 import (
 	"encoding/json"
-	"fmt"
 	log "github.com/Sirupsen/logrus"
+	"reflect"
+	"sort"
+	"strings"
 )
 
+/*
+Every type of state which may be declared in a state file and the struct its data is decoded into
+*/
+var stateTypes = map[string]reflect.Type{
+	"file":     reflect.TypeOf(File{}),
+	"package":  reflect.TypeOf(Package{}),
+	"service":  reflect.TypeOf(Service{}),
+	"dockerd":  reflect.TypeOf(DockerDaemon{}),
+	"user":     reflect.TypeOf(User{}),
+	"group":    reflect.TypeOf(Group{}),
+	"sshkey":   reflect.TypeOf(SSHKey{}),
+	"sysctl":   reflect.TypeOf(Sysctl{}),
+	"kmod":     reflect.TypeOf(KernelModule{}),
+	"mount":    reflect.TypeOf(Mount{}),
+	"swap":     reflect.TypeOf(Swap{}),
+	"cmd":      reflect.TypeOf(Command{}),
+	"line":     reflect.TypeOf(Line{}),
+	"block":    reflect.TypeOf(Block{}),
+	"config":   reflect.TypeOf(Config{}),
+	"archive":  reflect.TypeOf(Archive{}),
+	"git":      reflect.TypeOf(Git{}),
+	"cron":     reflect.TypeOf(Cron{}),
+	"hostname": reflect.TypeOf(Hostname{}),
+	"host":     reflect.TypeOf(Host{}),
+	"timezone": reflect.TypeOf(Timezone{}),
+	"iptables": reflect.TypeOf(Iptables{}),
+	"cert":     reflect.TypeOf(Cert{}),
+}
+
+/*
+Return the names of every type of state in a stable order
+*/
+func stateTypeNames() []string {
+	names := make([]string, 0)
+	for name := range stateTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+/*
+Return the kind of the field a key of a state's data is decoded into, reflect.Invalid if the type or key is unknown
+*/
+func stateFieldKind(stateType, key string) reflect.Kind {
+	structType, exists := stateTypes[stateType]
+	if !exists {
+		return reflect.Invalid
+	}
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if strings.Split(field.Tag.Get("json"), ",")[0] == key {
+			return field.Type.Kind()
+		}
+	}
+	return reflect.Invalid
+}
+
 func StateFactory(metadata Metadata, data []byte) (State, error) {
 	log.Printf("Loading state %s: %s.%s", metadata.Name, metadata.Type, metadata.State)
-	err := json.Unmarshal(data, &metadata) // Load requirements from each state into Metadata
+	common := struct {
+		Requirements []string `json:"require"`
+		When         string   `json:"when"`
+	}{metadata.Requirements, metadata.When}
+	err := json.Unmarshal(data, &common) // Load requirements and conditions from each state into Metadata
 	if err != nil {
-		panic(err)
+		return nil, &DecodeError{Metadata: &metadata, Err: err}
+	}
+	metadata.Requirements, metadata.When = common.Requirements, common.When
+	if metadata.When != "" {
+		if _, err := parseWhen(metadata.When); err != nil {
+			return nil, err
+		}
 	}
 	switch metadata.Type {
 	case "file":
@@ -19,7 +89,47 @@ func StateFactory(metadata Metadata, data []byte) (State, error) {
 		return newPackage(metadata, data)
 	case "service":
 		return newService(metadata, data)
+	case "dockerd":
+		return newDockerDaemon(metadata, data)
+	case "user":
+		return newUser(metadata, data)
+	case "group":
+		return newGroup(metadata, data)
+	case "sshkey":
+		return newSSHKey(metadata, data)
+	case "sysctl":
+		return newSysctl(metadata, data)
+	case "kmod":
+		return newKernelModule(metadata, data)
+	case "mount":
+		return newMount(metadata, data)
+	case "swap":
+		return newSwap(metadata, data)
+	case "cmd":
+		return newCommand(metadata, data)
+	case "line":
+		return newLine(metadata, data)
+	case "block":
+		return newBlock(metadata, data)
+	case "config":
+		return newConfig(metadata, data)
+	case "archive":
+		return newArchive(metadata, data)
+	case "git":
+		return newGit(metadata, data)
+	case "cron":
+		return newCron(metadata, data)
+	case "hostname":
+		return newHostname(metadata, data)
+	case "host":
+		return newHost(metadata, data)
+	case "timezone":
+		return newTimezone(metadata, data)
+	case "iptables":
+		return newIptables(metadata, data)
+	case "cert":
+		return newCert(metadata, data)
 	default:
-		panic(fmt.Errorf("Unknown state keyword: %s", metadata.Type))
+		return nil, &UnknownTypeError{Type: metadata.Type, Suggestion: suggest(metadata.Type, stateTypeNames())}
 	}
 }
@@ -67,3 +67,21 @@ func TestStateFactoryService(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestStateFactoryErrors(t *testing.T) {
+	_, err := StateFactory(Metadata{Name: "docker", Type: "servce", State: "running"}, simpleService)
+	if typed, ok := err.(*UnknownTypeError); !ok || typed.Suggestion != "service" {
+		fmt.Println("Failed to return an unknown type error: ", err)
+		t.Fail()
+	}
+	_, err = StateFactory(simpleServiceMeta, []byte(`{"require": "docker"`))
+	if _, ok := err.(*DecodeError); !ok {
+		fmt.Println("Failed to return a decode error: ", err)
+		t.Fail()
+	}
+	_, err = StateMapFromProcessedJson([]byte(`{"metadata": {}}`))
+	if _, ok := err.(*DecodeError); !ok {
+		fmt.Println("Failed to return a decode error for an invalid payload: ", err)
+		t.Fail()
+	}
+}
@@ -124,22 +124,35 @@ func newFile(metadata Metadata, data []byte) (*File, error) {
 Retrieve a file from a remote source
 */
 func (file *File) retrieveFile() ([]byte, error) {
+	return retrieveSource(file.Source)
+}
+
+/*
+Retrieve the contents of a source, sources may be http://, https:// or file:// URLs
+*/
+func retrieveSource(source string) ([]byte, error) {
 	var body []byte
 	switch {
-	case strings.Contains(file.Source, "http://"):
-		log.Printf("Calling HTTP GET: %s", file.Source)
-		resp, err := http.Get(file.Source)
+	case strings.HasPrefix(source, "http://"), strings.HasPrefix(source, "https://"):
+		log.Printf("Calling HTTP GET: %s", source)
+		resp, err := http.Get(source)
 		if err != nil {
 			return body, err
 		}
 		defer resp.Body.Close() // TODO: Buffer and error if a maximum file size is exceeded.
+		if resp.StatusCode != http.StatusOK {
+			return body, fmt.Errorf("Unable to retrieve %s: %s", source, resp.Status)
+		}
 		body, err = ioutil.ReadAll(resp.Body)
 		if err != nil {
 			return body, err
 		}
 		return body, nil
+	case strings.HasPrefix(source, "file://"):
+		log.Printf("Reading local file: %s", source)
+		return ioutil.ReadFile(strings.TrimPrefix(source, "file://"))
 	default:
-		return body, fmt.Errorf("Unable to parse source type: %s", file.Source)
+		return body, fmt.Errorf("Unable to parse source type: %s", source)
 	}
 }
 
@@ -0,0 +1,156 @@
+package state
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+var fstabPath = "/etc/fstab"
+
+type fstabEntry struct {
+	Device  string
+	Path    string
+	FSType  string
+	Options string
+	Dump    string
+	Pass    string
+}
+
+/*
+Render the entry as a line in /etc/fstab
+*/
+func (entry fstabEntry) String() string {
+	return strings.Join([]string{
+		escapeMountPath(entry.Device),
+		escapeMountPath(entry.Path),
+		entry.FSType,
+		entry.Options,
+		entry.Dump,
+		entry.Pass,
+	}, "\t")
+}
+
+/*
+Parse a single line of /etc/fstab, comments and blank lines are not entries
+*/
+func parseFstabLine(line string) (fstabEntry, bool) {
+	entry := fstabEntry{Options: "defaults", Dump: "0", Pass: "0"}
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		return entry, false
+	}
+	fields := strings.Fields(trimmed)
+	if len(fields) < 3 {
+		return entry, false
+	}
+	entry.Device = unescapeMountPath(fields[0])
+	entry.Path = unescapeMountPath(fields[1])
+	entry.FSType = fields[2]
+	if len(fields) > 3 {
+		entry.Options = fields[3]
+	}
+	if len(fields) > 4 {
+		entry.Dump = fields[4]
+	}
+	if len(fields) > 5 {
+		entry.Pass = fields[5]
+	}
+	return entry, true
+}
+
+/*
+Read the lines of /etc/fstab, a missing file has no lines
+*/
+func readFstab() ([]string, error) {
+	data, err := ioutil.ReadFile(fstabPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	if len(data) == 0 {
+		return []string{}, nil
+	}
+	return strings.Split(strings.TrimRight(string(data), "\n"), "\n"), nil
+}
+
+/*
+Write the lines of /etc/fstab, keeping the mode of the existing file
+*/
+func writeFstab(lines []string) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(fstabPath); err == nil {
+		mode = info.Mode()
+	}
+	log.Printf("Updating %s", fstabPath)
+	return ioutil.WriteFile(fstabPath, []byte(strings.Join(lines, "\n")+"\n"), mode)
+}
+
+/*
+Find the fstab entry for the given mount point
+*/
+func findFstabEntry(lines []string, path string) (fstabEntry, bool) {
+	for _, line := range lines {
+		if entry, ok := parseFstabLine(line); ok && entry.Path == path {
+			return entry, true
+		}
+	}
+	return fstabEntry{}, false
+}
+
+/*
+Replace the fstab entry for a mount point, a nil entry removes it
+*/
+func updateFstabEntry(lines []string, path string, replacement *fstabEntry) []string {
+	updated := make([]string, 0)
+	written := false
+	for _, line := range lines {
+		if entry, ok := parseFstabLine(line); ok && entry.Path == path {
+			if replacement != nil && !written {
+				updated = append(updated, replacement.String())
+				written = true
+			}
+			continue
+		}
+		updated = append(updated, line)
+	}
+	if replacement != nil && !written {
+		updated = append(updated, replacement.String())
+	}
+	return updated
+}
+
+/*
+Replace the octal escapes used for whitespace in fstab and mountinfo
+*/
+func unescapeMountPath(path string) string {
+	replacer := strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`)
+	return replacer.Replace(path)
+}
+
+func escapeMountPath(path string) string {
+	replacer := strings.NewReplacer(`\`, `\134`, " ", `\040`, "\t", `\011`, "\n", `\012`)
+	return replacer.Replace(path)
+}
+
+/*
+Return each option in wanted which does not appear in options
+*/
+func missingOptions(options []string, wanted []string) []string {
+	missing := make([]string, 0)
+	for _, want := range wanted {
+		found := false
+		for _, option := range options {
+			if option == want {
+				found = true
+			}
+		}
+		if !found {
+			missing = append(missing, want)
+		}
+	}
+	return missing
+}
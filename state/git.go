@@ -0,0 +1,205 @@
+/*
+A Git represents a working copy of a git repository checked out at a branch, tag or commit.
+The repository may be any URL understood by git, including the path of a local bare repository.
+States -
+  latest: The working copy exists and is checked out at the latest revision of rev
+*/
+
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var commitPattern = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+type Git struct {
+	Repository string   `json:"repository"` // URL of the repository to clone
+	Path       string   `json:"path"`       // Path of the working copy, defaults to the state name
+	Rev        string   `json:"rev"`        // Branch, tag or commit to check out, defaults to the remote HEAD
+	Force      bool     `json:"force"`      // Discard local modifications to the working copy
+	Metadata   Metadata `json:"metadata"`
+}
+
+func (git *Git) Meta() Metadata {
+	return git.Metadata
+}
+
+func (git *Git) State() *Result {
+	result := &Result{
+		Metadata:   &git.Metadata,
+		Consistent: false,
+	}
+	if _, err := os.Stat(filepath.Join(git.Path, ".git")); err != nil {
+		result.Message = fmt.Sprintf("%s is not cloned to %s", git.Repository, git.Path)
+		return result
+	}
+	current, err := git.revision("HEAD")
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	result.Details = map[string]string{"old": current, "new": current}
+	remote, err := git.git("config", "--get", "remote.origin.url")
+	if err != nil || remote != git.Repository {
+		result.Message = fmt.Sprintf("%s has origin %s, expected %s", git.Path, remote, git.Repository)
+		return result
+	}
+	target, err := git.target()
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	if target != current {
+		result.Message = fmt.Sprintf("%s is at %s, %s is at %s", git.Path, current, git.rev(), target)
+		return result
+	}
+	result.Consistent = true
+	return result
+}
+
+func (git *Git) Apply() *Result {
+	result := git.State()
+	if result.Consistent == true {
+		return result
+	}
+	old := result.Details["old"]
+	if _, err := os.Stat(filepath.Join(git.Path, ".git")); err != nil {
+		err = runCommand("git", "clone", "--no-checkout", git.Repository, git.Path)
+		if err != nil {
+			result.Message = err.Error()
+			return result
+		}
+	} else if !git.Force {
+		changes, err := git.git("status", "--porcelain", "--untracked-files=no")
+		if err != nil || changes != "" {
+			result.Message = fmt.Sprintf("%s has local modifications", git.Path)
+			return result
+		}
+	}
+	target, err := git.checkout()
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	result.Details = map[string]string{"old": old, "new": target}
+	if old == "" {
+		result.Message = fmt.Sprintf("Cloned %s at %s", git.Repository, target)
+	} else {
+		result.Message = fmt.Sprintf("Updated %s from %s to %s", git.Path, old, target)
+	}
+	result.Consistent = true
+	return result
+}
+
+/*
+Create and validate a new Git State
+*/
+func newGit(metadata Metadata, data []byte) (*Git, error) {
+	git := &Git{}
+	err := json.Unmarshal(data, &git)
+	if err != nil {
+		return nil, err
+	}
+	git.Metadata = metadata
+	switch metadata.State {
+	case "latest":
+	default:
+		return nil, fmt.Errorf("Invalid git state: %s", metadata.State)
+	}
+	if git.Repository == "" {
+		return nil, fmt.Errorf("No repository specified for %s", metadata.Name)
+	}
+	if git.Path == "" {
+		git.Path = metadata.Name
+	}
+	return git, nil
+}
+
+func (git *Git) rev() string {
+	if git.Rev == "" {
+		return "HEAD"
+	}
+	return git.Rev
+}
+
+/*
+Run git within the working copy and return its trimmed output
+*/
+func (git *Git) git(args ...string) (string, error) {
+	out, err := commandOutput("git", append([]string{"-C", git.Path}, args...)...)
+	return strings.TrimSpace(out), err
+}
+
+/*
+Resolve a revision of the working copy to a commit
+*/
+func (git *Git) revision(rev string) (string, error) {
+	return git.git("rev-parse", "--verify", "--quiet", rev+"^{commit}")
+}
+
+/*
+Resolve rev to a commit on the remote repository, commits which are not a branch or tag are resolved locally
+*/
+func (git *Git) target() (string, error) {
+	out, err := commandOutput("git", "ls-remote", git.Repository)
+	if err != nil {
+		return "", err
+	}
+	refs := parseLsRemote(out)
+	rev := git.rev()
+	for _, ref := range []string{rev, "refs/heads/" + rev, "refs/tags/" + rev + "^{}", "refs/tags/" + rev} {
+		if commit, ok := refs[ref]; ok {
+			return commit, nil
+		}
+	}
+	if commitPattern.MatchString(rev) {
+		if len(rev) == 40 {
+			return rev, nil
+		}
+		if commit, err := git.revision(rev); err == nil {
+			return commit, nil
+		}
+		return "", fmt.Errorf("Commit %s is not present in %s", rev, git.Path)
+	}
+	return "", fmt.Errorf("Unknown revision %s in %s", rev, git.Repository)
+}
+
+/*
+Fetch the remote repository and check out rev, returning the new commit
+*/
+func (git *Git) checkout() (string, error) {
+	if _, err := git.git("remote", "set-url", "origin", git.Repository); err != nil {
+		return "", err
+	}
+	if _, err := git.git("fetch", "--tags", "--force", "origin"); err != nil {
+		return "", err
+	}
+	target, err := git.target()
+	if err != nil {
+		return "", err
+	}
+	if _, err := git.git("checkout", "--force", "--detach", target); err != nil {
+		return "", err
+	}
+	return git.revision("HEAD")
+}
+
+/*
+Parse the output of git ls-remote into a map of references to commits
+*/
+func parseLsRemote(out string) map[string]string {
+	refs := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			refs[fields[1]] = fields[0]
+		}
+	}
+	return refs
+}
@@ -0,0 +1,105 @@
+package state
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/*
+Create a bare repository with two commits on master and a tag on the first, returning both commits
+*/
+func gitRepoSetup(dir string, t *testing.T) (string, string) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	bare := filepath.Join(dir, "otter.git")
+	work := filepath.Join(dir, "work")
+	run := func(args ...string) string {
+		args = append([]string{"-c", "user.name=otter", "-c", "user.email=otter@localhost"}, args...)
+		out, err := exec.Command("git", args...).CombinedOutput()
+		if err != nil {
+			t.Fatal(string(out))
+		}
+		return strings.TrimSpace(string(out))
+	}
+	run("init", "--bare", bare)
+	run("-C", bare, "symbolic-ref", "HEAD", "refs/heads/master")
+	run("init", work)
+	ioutil.WriteFile(filepath.Join(work, "README.md"), []byte("v1\n"), 0644)
+	run("-C", work, "add", "README.md")
+	run("-C", work, "commit", "-m", "v1")
+	run("-C", work, "tag", "-a", "v1", "-m", "v1")
+	first := run("-C", work, "rev-parse", "HEAD")
+	ioutil.WriteFile(filepath.Join(work, "README.md"), []byte("v2\n"), 0644)
+	run("-C", work, "commit", "-am", "v2")
+	second := run("-C", work, "rev-parse", "HEAD")
+	run("-C", work, "push", "--tags", bare, "HEAD:refs/heads/master")
+	return first, second
+}
+
+func gitSetup(dir, rev string, t *testing.T) State {
+	metadata := Metadata{Name: "otter", Type: "git", State: "latest"}
+	data := fmt.Sprintf(`{"repository": "%s", "path": "%s", "rev": "%s"}`, filepath.Join(dir, "otter.git"), filepath.Join(dir, "checkout"), rev)
+	return stateSetup(metadata, []byte(data), t)
+}
+
+func TestGitLatest(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "otter-git")
+	defer os.RemoveAll(dir)
+	first, second := gitRepoSetup(dir, t)
+	state := gitSetup(dir, "master", t)
+	if state.State().Consistent {
+		fmt.Println("Repository should not be cloned")
+		t.Fail()
+	}
+	result := state.Apply()
+	if !result.Consistent || result.Details["old"] != "" || result.Details["new"] != second {
+		fmt.Println("Failed to clone repository: ", result.Message, result.Details)
+		t.Fail()
+	}
+	if !state.State().Consistent {
+		fmt.Println("Repository should be consistent")
+		t.Fail()
+	}
+	state = gitSetup(dir, "v1", t)
+	result = state.Apply()
+	if !result.Consistent || result.Details["old"] != second || result.Details["new"] != first {
+		fmt.Println("Failed to check out tag: ", result.Message, result.Details)
+		t.Fail()
+	}
+	data, _ := ioutil.ReadFile(filepath.Join(dir, "checkout", "README.md"))
+	if string(data) != "v1\n" {
+		fmt.Println("Bad checkout: ", string(data))
+		t.Fail()
+	}
+	state = gitSetup(dir, second[:10], t)
+	if result = state.Apply(); !result.Consistent || result.Details["new"] != second {
+		fmt.Println("Failed to check out commit: ", result.Message, result.Details)
+		t.Fail()
+	}
+}
+
+func TestGitLocalModifications(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "otter-git")
+	defer os.RemoveAll(dir)
+	gitRepoSetup(dir, t)
+	gitSetup(dir, "master", t).Apply()
+	ioutil.WriteFile(filepath.Join(dir, "checkout", "README.md"), []byte("modified\n"), 0644)
+	if result := gitSetup(dir, "v1", t).Apply(); result.Consistent {
+		fmt.Println("Local modifications should not be discarded")
+		t.Fail()
+	}
+}
+
+func TestParseLsRemote(t *testing.T) {
+	refs := parseLsRemote("abc\tHEAD\nabc\trefs/heads/master\ndef\trefs/tags/v1\nabc\trefs/tags/v1^{}\n")
+	if refs["refs/heads/master"] != "abc" || refs["refs/tags/v1^{}"] != "abc" || refs["refs/tags/v1"] != "def" {
+		fmt.Println("Bad ls-remote references: ", refs)
+		t.Fail()
+	}
+}
@@ -0,0 +1,191 @@
+/*
+A Group represents a group account on an operating system.
+States -
+  present: The group exists in /etc/group
+  absent: The group does not exist in /etc/group
+*/
+
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+var groupPath = "/etc/group"
+
+type Group struct {
+	Groupname string   `json:"groupname"` // Name of the group, defaults to the state name
+	GID       string   `json:"gid"`       // Numeric group id
+	System    bool     `json:"system"`    // Create the group as a system group
+	Metadata  Metadata `json:"metadata"`
+}
+
+type groupEntry struct {
+	Name    string
+	GID     string
+	Members []string
+}
+
+func (group *Group) Meta() Metadata {
+	return group.Metadata
+}
+
+func (group *Group) State() *Result {
+	result := &Result{
+		Metadata:   &group.Metadata,
+		Consistent: false,
+	}
+	groups, err := readGroups()
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	entry, exists := groups[group.Groupname]
+	switch group.Metadata.State {
+	case "present":
+		if !exists {
+			result.Message = fmt.Sprintf("Group %s does not exist", group.Groupname)
+			return result
+		}
+		if group.GID != "" && entry.GID != group.GID {
+			result.Message = fmt.Sprintf("Group %s has gid %s, expected %s", group.Groupname, entry.GID, group.GID)
+			return result
+		}
+	case "absent":
+		if exists {
+			result.Message = fmt.Sprintf("Group %s exists", group.Groupname)
+			return result
+		}
+	}
+	result.Consistent = true
+	return result
+}
+
+func (group *Group) Apply() *Result {
+	result := group.State()
+	if result.Consistent == true {
+		return result
+	}
+	switch group.Metadata.State {
+	case "present":
+		groups, err := readGroups()
+		if err != nil {
+			result.Message = err.Error()
+			return result
+		}
+		if _, exists := groups[group.Groupname]; exists {
+			err = runCommand("groupmod", "-g", group.GID, group.Groupname)
+		} else {
+			err = runCommand("groupadd", group.groupaddArgs()...)
+		}
+		if err != nil {
+			result.Message = err.Error()
+			return result
+		}
+		result.Message = "Group present"
+		result.Consistent = true
+	case "absent":
+		err := runCommand("groupdel", group.Groupname)
+		if err != nil {
+			result.Message = err.Error()
+			return result
+		}
+		result.Message = "Group removed"
+		result.Consistent = true
+	}
+	return result
+}
+
+/*
+Create and validate a new Group State
+*/
+func newGroup(metadata Metadata, data []byte) (*Group, error) {
+	group := &Group{}
+	err := json.Unmarshal(data, &group)
+	if err != nil {
+		return nil, err
+	}
+	group.Metadata = metadata
+	switch metadata.State {
+	case "present":
+	case "absent":
+	default:
+		return nil, fmt.Errorf("Invalid group state: %s", metadata.State)
+	}
+	if group.Groupname == "" {
+		group.Groupname = metadata.Name
+	}
+	if group.GID != "" && !isNumeric(group.GID) {
+		return nil, fmt.Errorf("Invalid gid for group %s: %s", group.Groupname, group.GID)
+	}
+	return group, nil
+}
+
+/*
+Build the arguments passed to groupadd
+*/
+func (group *Group) groupaddArgs() []string {
+	args := make([]string, 0)
+	if group.GID != "" {
+		args = append(args, "-g", group.GID)
+	}
+	if group.System {
+		args = append(args, "-r")
+	}
+	return append(args, group.Groupname)
+}
+
+/*
+Read and parse the group database
+*/
+func readGroups() (map[string]groupEntry, error) {
+	data, err := ioutil.ReadFile(groupPath)
+	if err != nil {
+		return nil, err
+	}
+	return parseGroup(data)
+}
+
+/*
+Parse the contents of an /etc/group formatted file into a map of group names to entries
+*/
+func parseGroup(data []byte) (map[string]groupEntry, error) {
+	groups := make(map[string]groupEntry)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "+") || strings.HasPrefix(line, "-") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) != 4 || fields[0] == "" || !isNumeric(fields[2]) {
+			return nil, fmt.Errorf("Malformed group entry on line %d: %s", i+1, line)
+		}
+		members := make([]string, 0)
+		for _, member := range strings.Split(fields[3], ",") {
+			if member != "" {
+				members = append(members, member)
+			}
+		}
+		groups[fields[0]] = groupEntry{
+			Name:    fields[0],
+			GID:     fields[2],
+			Members: members,
+		}
+	}
+	return groups, nil
+}
+
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,68 @@
+package state
+
+import (
+	"fmt"
+	"testing"
+)
+
+var groupFile = []byte(`
+root:x:0:
+# comment lines are ignored
+docker:x:999:deploy,jenkins
+deploy:x:1001:
++nisgroup
+`)
+
+var simpleGroupMeta = Metadata{
+	Name:  "docker",
+	Type:  "group",
+	State: "present",
+}
+
+func TestParseGroup(t *testing.T) {
+	groups, err := parseGroup(groupFile)
+	if err != nil {
+		fmt.Println("Failed to parse group file: ", err)
+		t.FailNow()
+	}
+	if len(groups) != 3 {
+		fmt.Println("Did not parse correct amount of groups: ", len(groups))
+		t.Fail()
+	}
+	docker := groups["docker"]
+	if docker.GID != "999" || len(docker.Members) != 2 || docker.Members[1] != "jenkins" {
+		fmt.Println("Bad group entry: ", docker)
+		t.Fail()
+	}
+	if len(groups["deploy"].Members) != 0 {
+		fmt.Println("Parsed members for group without members: ", groups["deploy"].Members)
+		t.Fail()
+	}
+}
+
+func TestParseGroupMalformed(t *testing.T) {
+	for _, data := range []string{"docker:x:999", "docker:x:abc:", ":x:10:"} {
+		_, err := parseGroup([]byte(data))
+		if err == nil {
+			fmt.Println("Failed to detect malformed group entry: ", data)
+			t.Fail()
+		}
+	}
+}
+
+func TestGroupArgs(t *testing.T) {
+	state := stateSetup(simpleGroupMeta, []byte(`{"gid": "999", "system": true}`), t)
+	args := fmt.Sprint(state.(*Group).groupaddArgs())
+	if args != "[-g 999 -r docker]" {
+		fmt.Println("Bad groupadd arguments: ", args)
+		t.Fail()
+	}
+}
+
+func TestGroupInvalidGID(t *testing.T) {
+	_, err := StateFactory(simpleGroupMeta, []byte(`{"gid": "docker"}`))
+	if err == nil {
+		fmt.Println("Failed to detect non-numeric gid")
+		t.Fail()
+	}
+}
@@ -0,0 +1,158 @@
+/*
+A Host represents an entry in /etc/hosts mapping a host name and its aliases to an address.
+Other entries are left untouched, the host name and aliases are only removed from lines which contain them.
+States -
+  present: The host name and aliases resolve to the address
+  absent: The host name and aliases do not appear in /etc/hosts
+*/
+
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/vektorlab/otter/helpers"
+	"net"
+	"strings"
+)
+
+var hostsPath = "/etc/hosts"
+
+type Host struct {
+	Hostname string   `json:"hostname"` // Host name, defaults to the state name
+	Address  string   `json:"address"`  // IPv4 or IPv6 address of the host
+	Aliases  []string `json:"aliases"`  // Additional names of the host
+	Metadata Metadata `json:"metadata"`
+}
+
+func (host *Host) Meta() Metadata {
+	return host.Metadata
+}
+
+func (host *Host) State() *Result {
+	result := &Result{
+		Metadata:   &host.Metadata,
+		Consistent: false,
+	}
+	content, err := readManagedFile(hostsPath, true)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	if diff := helpers.Diff(content, host.edit(content)); diff != "" {
+		result.Message = fmt.Sprintf("%s would change", hostsPath)
+		result.Details = map[string]string{"diff": diff}
+		return result
+	}
+	result.Consistent = true
+	return result
+}
+
+func (host *Host) Apply() *Result {
+	result := host.State()
+	if result.Consistent == true {
+		return result
+	}
+	content, err := readManagedFile(hostsPath, true)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	err = writeManagedFile(hostsPath, host.edit(content))
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	result.Message = fmt.Sprintf("%s updated", hostsPath)
+	result.Consistent = true
+	return result
+}
+
+/*
+Create and validate a new Host State
+*/
+func newHost(metadata Metadata, data []byte) (*Host, error) {
+	host := &Host{}
+	err := json.Unmarshal(data, &host)
+	if err != nil {
+		return nil, err
+	}
+	host.Metadata = metadata
+	if host.Hostname == "" {
+		host.Hostname = metadata.Name
+	}
+	switch metadata.State {
+	case "present":
+		if net.ParseIP(host.Address) == nil {
+			return nil, fmt.Errorf("Invalid address for host %s: %q", host.Hostname, host.Address)
+		}
+	case "absent":
+	default:
+		return nil, fmt.Errorf("Invalid host state: %s", metadata.State)
+	}
+	for _, name := range host.names() {
+		if !hostnamePattern.MatchString(name) {
+			return nil, fmt.Errorf("Invalid host name: %s", name)
+		}
+	}
+	return host, nil
+}
+
+func (host *Host) names() []string {
+	return append([]string{host.Hostname}, host.Aliases...)
+}
+
+/*
+Return the content of the hosts file with the entry applied.
+The managed names are removed from every line, the entry replaces the first line with the same address which contained
+them or is appended if there is no such line.
+*/
+func (host *Host) edit(content string) string {
+	managed := make(map[string]bool)
+	for _, name := range host.names() {
+		managed[name] = true
+	}
+	entry := fmt.Sprintf("%s\t%s", host.Address, strings.Join(host.names(), " "))
+	edited := make([]string, 0)
+	found := false
+	for _, line := range splitFileLines(content) {
+		fields, comment := parseHostsLine(line)
+		if len(fields) < 2 {
+			edited = append(edited, line)
+			continue
+		}
+		names := make([]string, 0)
+		for _, name := range fields[1:] {
+			if !managed[name] {
+				names = append(names, name)
+			}
+		}
+		if len(names) == len(fields)-1 {
+			edited = append(edited, line)
+			continue
+		}
+		if host.Metadata.State == "present" && !found && fields[0] == host.Address {
+			edited = append(edited, entry)
+			found = true
+		}
+		if len(names) > 0 {
+			edited = append(edited, fmt.Sprintf("%s\t%s%s", fields[0], strings.Join(names, " "), comment))
+		}
+	}
+	if host.Metadata.State == "present" && !found {
+		edited = append(edited, entry)
+	}
+	return joinFileLines(edited)
+}
+
+/*
+Split a line of /etc/hosts into its fields and any trailing comment
+*/
+func parseHostsLine(line string) ([]string, string) {
+	comment := ""
+	if idx := strings.Index(line, "#"); idx >= 0 {
+		comment = " " + line[idx:]
+		line = line[:idx]
+	}
+	return strings.Fields(line), comment
+}
@@ -0,0 +1,61 @@
+package state
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var hostsFile = `127.0.0.1	localhost
+127.0.1.1	node1 node1.cluster.local # added by installer
+::1	localhost ip6-localhost
+`
+
+/*
+Point the hosts path at a temporary directory, the returned function restores it
+*/
+func hostSetup(state, data string, t *testing.T) (State, func()) {
+	dir, _ := ioutil.TempDir("", "otter-hosts")
+	oldHosts := hostsPath
+	restore := func() {
+		hostsPath = oldHosts
+		os.RemoveAll(dir)
+	}
+	hostsPath = filepath.Join(dir, "hosts")
+	ioutil.WriteFile(hostsPath, []byte(hostsFile), 0644)
+	return stateSetup(Metadata{Name: "node1", Type: "host", State: state}, []byte(data), t), restore
+}
+
+func TestHostPresent(t *testing.T) {
+	state, restore := hostSetup("present", `{"address": "10.0.0.5"}`, t)
+	defer restore()
+	if result := state.State(); result.Consistent || result.Details["diff"] != "-127.0.1.1\tnode1 node1.cluster.local # added by installer\n+127.0.1.1\tnode1.cluster.local # added by installer\n+10.0.0.5\tnode1" {
+		fmt.Println("Bad hosts diff: ", result.Details["diff"])
+		t.Fail()
+	}
+	state.Apply()
+	if !state.State().Consistent {
+		fmt.Println("Host should be present")
+		t.Fail()
+	}
+	state = stateSetup(Metadata{Name: "node1", Type: "host", State: "present"}, []byte(`{"address": "127.0.1.1", "aliases": ["node1.cluster.local"]}`), t)
+	state.Apply()
+	data, _ := ioutil.ReadFile(hostsPath)
+	if string(data) != "127.0.0.1\tlocalhost\n127.0.1.1\tnode1 node1.cluster.local\n::1\tlocalhost ip6-localhost\n" {
+		fmt.Println("Bad hosts entry: ", string(data))
+		t.Fail()
+	}
+}
+
+func TestHostAbsent(t *testing.T) {
+	state, restore := hostSetup("absent", `{}`, t)
+	defer restore()
+	state.Apply()
+	data, _ := ioutil.ReadFile(hostsPath)
+	if string(data) != "127.0.0.1\tlocalhost\n127.0.1.1\tnode1.cluster.local # added by installer\n::1\tlocalhost ip6-localhost\n" {
+		fmt.Println("Bad hosts removal: ", string(data))
+		t.Fail()
+	}
+}
@@ -0,0 +1,124 @@
+/*
+A Hostname represents the host name of an operating system, both at runtime and persisted to /etc/hostname.
+States -
+  set: The host name is set at runtime and persisted
+*/
+
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var hostnamePath = "/etc/hostname"
+
+var hostnamePattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?)*$`)
+
+type Hostname struct {
+	Hostname string   `json:"hostname"` // Host name, defaults to the state name
+	Metadata Metadata `json:"metadata"`
+}
+
+func (hostname *Hostname) Meta() Metadata {
+	return hostname.Metadata
+}
+
+func (hostname *Hostname) State() *Result {
+	result := &Result{
+		Metadata:   &hostname.Metadata,
+		Consistent: false,
+	}
+	runtime, err := ioutil.ReadFile(hostname.procPath())
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	persisted, err := ioutil.ReadFile(hostnamePath)
+	if err != nil && !os.IsNotExist(err) {
+		result.Message = err.Error()
+		return result
+	}
+	result.Details = map[string]string{
+		"runtime":   strings.TrimSpace(string(runtime)),
+		"persisted": strings.TrimSpace(string(persisted)),
+	}
+	drift := make([]string, 0)
+	if result.Details["runtime"] != hostname.Hostname {
+		drift = append(drift, fmt.Sprintf("runtime host name is %q", result.Details["runtime"]))
+	}
+	if result.Details["persisted"] != hostname.Hostname {
+		drift = append(drift, fmt.Sprintf("host name in %s is %q", hostnamePath, result.Details["persisted"]))
+	}
+	if len(drift) > 0 {
+		result.Message = fmt.Sprintf("Expected host name %q: %s", hostname.Hostname, strings.Join(drift, ", "))
+		return result
+	}
+	result.Consistent = true
+	return result
+}
+
+func (hostname *Hostname) Apply() *Result {
+	result := hostname.State()
+	if result.Consistent == true {
+		return result
+	}
+	if result.Details["persisted"] != hostname.Hostname {
+		log.Printf("Writing host name %s to %s", hostname.Hostname, hostnamePath)
+		err := ioutil.WriteFile(hostnamePath, []byte(hostname.Hostname+"\n"), 0644)
+		if err != nil {
+			result.Message = err.Error()
+			return result
+		}
+	}
+	if result.Details["runtime"] != hostname.Hostname {
+		log.Printf("Setting host name %s", hostname.Hostname)
+		err := ioutil.WriteFile(hostname.procPath(), []byte(hostname.Hostname), 0644)
+		if err != nil {
+			result.Message = err.Error()
+			return result
+		}
+	}
+	result.Details["runtime"] = hostname.Hostname
+	result.Details["persisted"] = hostname.Hostname
+	result.Message = fmt.Sprintf("Host name set to %s", hostname.Hostname)
+	result.Consistent = true
+	return result
+}
+
+/*
+Create and validate a new Hostname State
+*/
+func newHostname(metadata Metadata, data []byte) (*Hostname, error) {
+	hostname := &Hostname{}
+	err := json.Unmarshal(data, &hostname)
+	if err != nil {
+		return nil, err
+	}
+	hostname.Metadata = metadata
+	switch metadata.State {
+	case "set":
+	default:
+		return nil, fmt.Errorf("Invalid hostname state: %s", metadata.State)
+	}
+	if hostname.Hostname == "" {
+		hostname.Hostname = metadata.Name
+	}
+	if len(hostname.Hostname) > 253 || !hostnamePattern.MatchString(hostname.Hostname) {
+		return nil, fmt.Errorf("Invalid host name: %s", hostname.Hostname)
+	}
+	return hostname, nil
+}
+
+/*
+The runtime host name is read and set through the kernel.hostname parameter
+*/
+func (hostname *Hostname) procPath() string {
+	return filepath.Join(procSysPath, "kernel", "hostname")
+}
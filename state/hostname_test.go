@@ -0,0 +1,42 @@
+package state
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHostnameSet(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "otter-hostname")
+	defer os.RemoveAll(dir)
+	oldProcSys, oldHostname := procSysPath, hostnamePath
+	defer func() { procSysPath, hostnamePath = oldProcSys, oldHostname }()
+	procSysPath = filepath.Join(dir, "proc")
+	hostnamePath = filepath.Join(dir, "hostname")
+	os.MkdirAll(filepath.Join(procSysPath, "kernel"), 0755)
+	ioutil.WriteFile(filepath.Join(procSysPath, "kernel", "hostname"), []byte("localhost\n"), 0644)
+	ioutil.WriteFile(hostnamePath, []byte("node1\n"), 0644)
+	state := stateSetup(Metadata{Name: "node1", Type: "hostname", State: "set"}, []byte(`{}`), t)
+	result := state.State()
+	if result.Consistent || result.Details["runtime"] != "localhost" || result.Details["persisted"] != "node1" {
+		fmt.Println("Failed to detect runtime drift: ", result.Message)
+		t.Fail()
+	}
+	state.Apply()
+	data, _ := ioutil.ReadFile(filepath.Join(procSysPath, "kernel", "hostname"))
+	if string(data) != "node1" || !state.State().Consistent {
+		fmt.Println("Failed to set runtime host name: ", string(data))
+		t.Fail()
+	}
+}
+
+func TestHostnameInvalid(t *testing.T) {
+	for _, name := range []string{"-node1", "node_1", "node1..local"} {
+		if _, err := newHostname(Metadata{Name: name, Type: "hostname", State: "set"}, []byte(`{}`)); err == nil {
+			fmt.Println("Host name should be invalid: ", name)
+			t.Fail()
+		}
+	}
+}
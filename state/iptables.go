@@ -0,0 +1,318 @@
+/*
+An Iptables represents a single firewall rule in a chain of an iptables table.
+Rules are checked with "iptables -C" so the rule specification should be written as iptables-save would print it.
+States -
+  present: The rule is present in the chain, it is inserted at position or appended. The position is only used when
+    the rule is inserted, a rule which is already present anywhere in the chain is not moved
+  absent: The rule is not present in the chain
+*/
+
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/vektorlab/otter/helpers"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Counters on chain lines change constantly and are ignored when comparing saved rules
+var chainCounters = regexp.MustCompile(` \[\d+:\d+\]$`)
+
+type Iptables struct {
+	Table    string   `json:"table"`    // Table containing the chain, defaults to "filter"
+	Chain    string   `json:"chain"`    // Chain containing the rule
+	Rule     string   `json:"rule"`     // Rule specification such as "-p tcp -m tcp --dport 6443 -j ACCEPT"
+	Position int      `json:"position"` // Insert the rule at this position when it is missing, the rule is appended when zero
+	Family   string   `json:"family"`   // "ipv4" or "ipv6", defaults to "ipv4"
+	Persist  bool     `json:"persist"`  // Save the rules so they are restored on boot
+	File     string   `json:"file"`     // File the rules are saved to, defaults to the distribution's save file
+	Metadata Metadata `json:"metadata"`
+	spec     []string
+}
+
+func (iptables *Iptables) Meta() Metadata {
+	return iptables.Metadata
+}
+
+func (iptables *Iptables) State() *Result {
+	result := &Result{
+		Metadata:   &iptables.Metadata,
+		Consistent: false,
+	}
+	present, err := iptables.exists()
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	switch iptables.Metadata.State {
+	case "present":
+		if !present {
+			result.Message = fmt.Sprintf("Rule is not present in %s/%s: %s", iptables.Table, iptables.Chain, iptables.Rule)
+			return result
+		}
+	case "absent":
+		if present {
+			result.Message = fmt.Sprintf("Rule is present in %s/%s: %s", iptables.Table, iptables.Chain, iptables.Rule)
+			return result
+		}
+	}
+	if iptables.Persist {
+		file, saved, err := iptables.saved()
+		if err != nil {
+			result.Message = err.Error()
+			return result
+		}
+		if !saved {
+			result.Message = fmt.Sprintf("Rules in table %s are not saved to %s", iptables.Table, file)
+			return result
+		}
+	}
+	result.Consistent = true
+	return result
+}
+
+func (iptables *Iptables) Apply() *Result {
+	result := iptables.State()
+	if result.Consistent == true {
+		return result
+	}
+	present, err := iptables.exists()
+	switch iptables.Metadata.State {
+	case "present":
+		if err == nil && !present {
+			if iptables.Position > 0 {
+				err = iptables.run("-I", iptables.Chain, strconv.Itoa(iptables.Position))
+			} else {
+				err = iptables.run("-A", iptables.Chain)
+			}
+		}
+	case "absent":
+		for err == nil && present { // Remove every copy of the rule
+			err = iptables.run("-D", iptables.Chain)
+			if err == nil {
+				present, err = iptables.exists()
+			}
+		}
+	}
+	if err == nil && iptables.Persist {
+		err = iptables.save()
+	}
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	result.Message = fmt.Sprintf("Rule %s in %s/%s: %s", iptables.Metadata.State, iptables.Table, iptables.Chain, iptables.Rule)
+	result.Consistent = true
+	return result
+}
+
+/*
+Create and validate a new Iptables State
+*/
+func newIptables(metadata Metadata, data []byte) (*Iptables, error) {
+	iptables := &Iptables{}
+	err := json.Unmarshal(data, &iptables)
+	if err != nil {
+		return nil, err
+	}
+	iptables.Metadata = metadata
+	switch metadata.State {
+	case "present":
+	case "absent":
+	default:
+		return nil, fmt.Errorf("Invalid iptables state: %s", metadata.State)
+	}
+	if iptables.Table == "" {
+		iptables.Table = "filter"
+	}
+	switch iptables.Table {
+	case "filter", "nat", "mangle", "raw", "security":
+	default:
+		return nil, fmt.Errorf("Invalid iptables table: %s", iptables.Table)
+	}
+	if iptables.Family == "" {
+		iptables.Family = "ipv4"
+	}
+	if iptables.Family != "ipv4" && iptables.Family != "ipv6" {
+		return nil, fmt.Errorf("Invalid iptables family: %s", iptables.Family)
+	}
+	if iptables.Chain == "" || strings.ContainsAny(iptables.Chain, " \t") {
+		return nil, fmt.Errorf("Invalid chain for rule %s: %q", metadata.Name, iptables.Chain)
+	}
+	if iptables.Position < 0 {
+		return nil, fmt.Errorf("Invalid position for rule %s: %d", metadata.Name, iptables.Position)
+	}
+	iptables.spec, err = splitShellWords(iptables.Rule)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid rule %s: %s", metadata.Name, err)
+	}
+	if len(iptables.spec) == 0 {
+		return nil, fmt.Errorf("No rule specified for %s", metadata.Name)
+	}
+	return iptables, nil
+}
+
+func (iptables *Iptables) command() string {
+	if iptables.Family == "ipv6" {
+		return "ip6tables"
+	}
+	return "iptables"
+}
+
+/*
+Run an iptables operation on the rule, waiting for the xtables lock if another process holds it
+*/
+func (iptables *Iptables) run(operation string, args ...string) error {
+	cmd := append([]string{"-w", "-t", iptables.Table, operation}, args...)
+	return runCommand(iptables.command(), append(cmd, iptables.spec...)...)
+}
+
+/*
+Check if the rule is in the chain, iptables -C exits with status 1 when it is not and any other failure is an error
+*/
+func (iptables *Iptables) exists() (bool, error) {
+	err := iptables.run("-C", iptables.Chain)
+	if err != nil && exitStatus(err) == 1 {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+/*
+Return the file rules are saved to, the location depends on the distribution
+*/
+func (iptables *Iptables) saveFile() (string, error) {
+	if iptables.File != "" {
+		return iptables.File, nil
+	}
+	distro, err := helpers.GetDistro()
+	if err != nil {
+		return "", err
+	}
+	switch distro.Family {
+	case "debian":
+		if iptables.Family == "ipv6" {
+			return "/etc/iptables/rules.v6", nil
+		}
+		return "/etc/iptables/rules.v4", nil
+	case "centos":
+		if iptables.Family == "ipv6" {
+			return "/etc/sysconfig/ip6tables", nil
+		}
+		return "/etc/sysconfig/iptables", nil
+	default:
+		return "", fmt.Errorf("Unsupported operating system: %s", distro.Family)
+	}
+}
+
+/*
+Check if the saved rules of the table match the running rules, the file they are saved to is returned with the result
+*/
+func (iptables *Iptables) saved() (string, bool, error) {
+	file, err := iptables.saveFile()
+	if err != nil {
+		return "", false, err
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return file, false, nil
+		}
+		return file, false, err
+	}
+	running, err := commandOutput(iptables.command()+"-save", "-t", iptables.Table)
+	if err != nil {
+		return file, false, err
+	}
+	return file, strings.Join(savedTable(running, iptables.Table), "\n") == strings.Join(savedTable(string(data), iptables.Table), "\n"), nil
+}
+
+/*
+Save the running rules of every table
+*/
+func (iptables *Iptables) save() error {
+	file, err := iptables.saveFile()
+	if err != nil {
+		return err
+	}
+	running, err := commandOutput(iptables.command() + "-save")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, []byte(running), 0600)
+}
+
+/*
+Return the lines of a table in iptables-save output without comments and counters
+*/
+func savedTable(data, table string) []string {
+	lines := make([]string, 0)
+	inTable := false
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "*"):
+			inTable = line == "*"+table
+		case inTable:
+			lines = append(lines, chainCounters.ReplaceAllString(line, ""))
+		}
+	}
+	return lines
+}
+
+/*
+Split a string into words as a shell would, supporting single quotes, double quotes and backslash escapes
+*/
+func splitShellWords(s string) ([]string, error) {
+	words := make([]string, 0)
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, c := range s {
+		switch {
+		case escaped:
+			word.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inWord = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("Unterminated quote or escape: %s", s)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,151 @@
+package state
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+/*
+A fakeIptables records commands and keeps the rules of the filter table in memory
+*/
+type fakeIptables struct {
+	rules    []string
+	commands []string
+	err      error // Returned by every command when set
+}
+
+func (fake *fakeIptables) Run(name string, args ...string) error {
+	fake.commands = append(fake.commands, name+" "+strings.Join(args, " "))
+	if fake.err != nil {
+		return fake.err
+	}
+	operation, chain, rule := args[3], args[4], strings.Join(args[5:], " ")
+	if operation == "-I" {
+		rule = strings.Join(args[6:], " ")
+	}
+	index := -1
+	for i, r := range fake.rules {
+		if r == chain+" "+rule {
+			index = i
+		}
+	}
+	switch operation {
+	case "-C":
+		if index < 0 {
+			return &commandError{name: name, err: fmt.Errorf("exit status 1"), output: "iptables: Bad rule (does a matching rule exist in that chain?)", status: 1}
+		}
+	case "-A":
+		fake.rules = append(fake.rules, chain+" "+rule)
+	case "-I":
+		fake.rules = append([]string{chain + " " + rule}, fake.rules...)
+	case "-D":
+		fake.rules = append(fake.rules[:index], fake.rules[index+1:]...)
+	}
+	return nil
+}
+
+func (fake *fakeIptables) Output(name string, args ...string) (string, error) {
+	fake.commands = append(fake.commands, name+" "+strings.Join(args, " "))
+	out := "# Generated by iptables-save\n*filter\n:INPUT ACCEPT [12:345]\n"
+	for _, rule := range fake.rules {
+		out += "-A " + rule + "\n"
+	}
+	return out + "COMMIT\n", nil
+}
+
+func iptablesSetup(state, data string, t *testing.T) (State, *fakeIptables) {
+	fake := &fakeIptables{}
+	commander = fake
+	return stateSetup(Metadata{Name: "kube-apiserver", Type: "iptables", State: state}, []byte(data), t), fake
+}
+
+func TestIptablesPresent(t *testing.T) {
+	defer func(old commandRunner) { commander = old }(commander)
+	dir, _ := ioutil.TempDir("", "otter-iptables")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "rules.v4")
+	state, fake := iptablesSetup("present", fmt.Sprintf(`{"chain": "INPUT", "rule": "-p tcp -m tcp --dport 6443 -m comment --comment \"kube apiserver\" -j ACCEPT", "position": 1, "persist": true, "file": "%s"}`, file), t)
+	if state.State().Consistent {
+		fmt.Println("Rule should not be present")
+		t.Fail()
+	}
+	state.Apply()
+	expected := []string{
+		"iptables -w -t filter -C INPUT -p tcp -m tcp --dport 6443 -m comment --comment kube apiserver -j ACCEPT",
+		"iptables -w -t filter -C INPUT -p tcp -m tcp --dport 6443 -m comment --comment kube apiserver -j ACCEPT",
+		"iptables -w -t filter -C INPUT -p tcp -m tcp --dport 6443 -m comment --comment kube apiserver -j ACCEPT",
+		"iptables -w -t filter -I INPUT 1 -p tcp -m tcp --dport 6443 -m comment --comment kube apiserver -j ACCEPT",
+		"iptables-save ",
+	}
+	if !reflect.DeepEqual(fake.commands, expected) {
+		fmt.Println("Bad iptables commands: ", strings.Join(fake.commands, "\n"))
+		t.Fail()
+	}
+	data, _ := ioutil.ReadFile(file)
+	if !strings.Contains(string(data), "-A INPUT -p tcp -m tcp --dport 6443") {
+		fmt.Println("Rules were not saved: ", string(data))
+		t.Fail()
+	}
+	if !state.State().Consistent {
+		fmt.Println("Rule should be present and saved")
+		t.Fail()
+	}
+	fake.rules = append(fake.rules, "INPUT -j DROP")
+	if state.State().Consistent {
+		fmt.Println("Unsaved rules should not be consistent")
+		t.Fail()
+	}
+}
+
+func TestIptablesAbsent(t *testing.T) {
+	defer func(old commandRunner) { commander = old }(commander)
+	state, fake := iptablesSetup("absent", `{"chain": "FORWARD", "rule": "-i docker0 -j ACCEPT"}`, t)
+	fake.rules = []string{"FORWARD -i docker0 -j ACCEPT", "FORWARD -o docker0 -j ACCEPT", "FORWARD -i docker0 -j ACCEPT"}
+	if !state.Apply().Consistent || !reflect.DeepEqual(fake.rules, []string{"FORWARD -o docker0 -j ACCEPT"}) {
+		fmt.Println("Failed to delete rule: ", fake.rules)
+		t.Fail()
+	}
+}
+
+func TestIptablesPosition(t *testing.T) {
+	defer func(old commandRunner) { commander = old }(commander)
+	state, fake := iptablesSetup("present", `{"chain": "INPUT", "rule": "-p tcp -m tcp --dport 6443 -j ACCEPT", "position": 1}`, t)
+	fake.rules = []string{"INPUT -i lo -j ACCEPT", "INPUT -p tcp -m tcp --dport 6443 -j ACCEPT"}
+	if !state.Apply().Consistent || len(fake.commands) != 1 {
+		fmt.Println("Rule present at another position should not be moved: ", fake.commands)
+		t.Fail()
+	}
+}
+
+func TestSplitShellWords(t *testing.T) {
+	words, err := splitShellWords(`-m comment --comment 'allow "all"' -s 10.0.0.0/8\ `)
+	if err != nil || !reflect.DeepEqual(words, []string{"-m", "comment", "--comment", `allow "all"`, "-s", "10.0.0.0/8 "}) {
+		fmt.Println("Bad shell words: ", words, err)
+		t.Fail()
+	}
+	if _, err := splitShellWords(`--comment "open`); err == nil {
+		fmt.Println("Unterminated quote should fail")
+		t.Fail()
+	}
+}
+
+func TestIptablesFailure(t *testing.T) {
+	defer func(old commandRunner) { commander = old }(commander)
+	for _, state := range []string{"present", "absent"} {
+		iptables, fake := iptablesSetup(state, `{"chain": "FORWARD", "rule": "-i docker0 -j ACCEPT"}`, t)
+		fake.err = &commandError{name: "iptables", err: fmt.Errorf("exec: not found"), status: -1}
+		if result := iptables.Apply(); result.Consistent || !strings.Contains(result.Message, "not found") {
+			fmt.Println("Failed to report iptables failure: ", result.Message)
+			t.Fail()
+		}
+		if len(fake.commands) != 2 {
+			fmt.Println("Modified rules after iptables failed: ", fake.commands)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,219 @@
+/*
+A KernelModule represents a loadable kernel module.
+States -
+  loaded: The module is loaded and listed in /etc/modules-load.d so it is loaded at boot
+  absent: The module is not loaded and is not loaded at boot
+*/
+
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	procModulesPath = "/proc/modules"
+	sysModulePath   = "/sys/module"
+	modulesLoadPath = "/etc/modules-load.d"
+)
+
+type KernelModule struct {
+	Module   string   `json:"module"` // Name of the kernel module, defaults to the state name
+	Metadata Metadata `json:"metadata"`
+}
+
+func (kmod *KernelModule) Meta() Metadata {
+	return kmod.Metadata
+}
+
+func (kmod *KernelModule) State() *Result {
+	result := &Result{
+		Metadata:   &kmod.Metadata,
+		Consistent: false,
+	}
+	loaded, err := kmod.loaded()
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	persisted, err := kmod.persisted()
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	switch kmod.Metadata.State {
+	case "loaded":
+		if !loaded {
+			result.Message = fmt.Sprintf("Module %s is not loaded", kmod.Module)
+			return result
+		}
+		if !persisted {
+			result.Message = fmt.Sprintf("Module %s is not loaded at boot", kmod.Module)
+			return result
+		}
+	case "absent":
+		if loaded {
+			result.Message = fmt.Sprintf("Module %s is loaded", kmod.Module)
+			return result
+		}
+		if persisted {
+			result.Message = fmt.Sprintf("Module %s is loaded at boot", kmod.Module)
+			return result
+		}
+	}
+	result.Consistent = true
+	return result
+}
+
+func (kmod *KernelModule) Apply() *Result {
+	result := kmod.State()
+	if result.Consistent == true {
+		return result
+	}
+	loaded, err := kmod.loaded()
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	switch kmod.Metadata.State {
+	case "loaded":
+		if !loaded {
+			err = runCommand("modprobe", kmod.Module)
+			if err != nil {
+				result.Message = err.Error()
+				return result
+			}
+		}
+		err = kmod.persist()
+		if err != nil {
+			result.Message = err.Error()
+			return result
+		}
+		result.Message = "Module loaded"
+		result.Consistent = true
+	case "absent":
+		if loaded {
+			err = runCommand("rmmod", kmod.Module)
+			if err != nil {
+				result.Message = err.Error()
+				return result
+			}
+		}
+		err = os.Remove(kmod.persistPath())
+		if err != nil && !os.IsNotExist(err) {
+			result.Message = err.Error()
+			return result
+		}
+		result.Message = "Module removed"
+		result.Consistent = true
+	}
+	return result
+}
+
+/*
+Create and validate a new KernelModule State
+*/
+func newKernelModule(metadata Metadata, data []byte) (*KernelModule, error) {
+	kmod := &KernelModule{}
+	err := json.Unmarshal(data, &kmod)
+	if err != nil {
+		return nil, err
+	}
+	kmod.Metadata = metadata
+	switch metadata.State {
+	case "loaded":
+	case "absent":
+	default:
+		return nil, fmt.Errorf("Invalid kmod state: %s", metadata.State)
+	}
+	if kmod.Module == "" {
+		kmod.Module = metadata.Name
+	}
+	if strings.ContainsAny(kmod.Module, " /") {
+		return nil, fmt.Errorf("Invalid kernel module name: %s", kmod.Module)
+	}
+	return kmod, nil
+}
+
+/*
+Check if the module is loaded, modules built into the kernel are considered loaded
+*/
+func (kmod *KernelModule) loaded() (bool, error) {
+	data, err := ioutil.ReadFile(procModulesPath)
+	if err != nil {
+		return false, err
+	}
+	name := normalizeModuleName(kmod.Module)
+	for _, module := range parseProcModules(data) {
+		if module == name {
+			return true, nil
+		}
+	}
+	if _, err := os.Stat(filepath.Join(sysModulePath, name)); err == nil {
+		return true, nil
+	}
+	return false, nil
+}
+
+/*
+Check if the module is listed in the modules-load.d file managed by this state
+*/
+func (kmod *KernelModule) persisted() (bool, error) {
+	data, err := ioutil.ReadFile(kmod.persistPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if normalizeModuleName(line) == normalizeModuleName(kmod.Module) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+/*
+Write the module to its modules-load.d file so it is loaded at boot
+*/
+func (kmod *KernelModule) persist() error {
+	err := os.MkdirAll(modulesLoadPath, 0755)
+	if err != nil {
+		return err
+	}
+	log.Printf("Persisting kernel module %s to %s", kmod.Module, kmod.persistPath())
+	return ioutil.WriteFile(kmod.persistPath(), []byte(kmod.Module+"\n"), 0644)
+}
+
+func (kmod *KernelModule) persistPath() string {
+	return filepath.Join(modulesLoadPath, kmod.Module+".conf")
+}
+
+/*
+Parse the names of all loaded modules from /proc/modules
+*/
+func parseProcModules(data []byte) []string {
+	modules := make([]string, 0)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 {
+			modules = append(modules, fields[0])
+		}
+	}
+	return modules
+}
+
+/*
+The kernel treats dashes and underscores in module names as equivalent
+*/
+func normalizeModuleName(name string) string {
+	return strings.Replace(name, "-", "_", -1)
+}
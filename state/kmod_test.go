@@ -0,0 +1,127 @@
+package state
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var procModules = []byte(`br_netfilter 24576 0 - Live 0x0000000000000000
+bridge 176128 1 br_netfilter, Live 0x0000000000000000
+overlay 118784 0 - Live 0x0000000000000000
+`)
+
+/*
+A fakeModprobe records commands instead of loading or unloading modules
+*/
+type fakeModprobe struct {
+	commands []string
+}
+
+func (fake *fakeModprobe) Run(name string, args ...string) error {
+	fake.commands = append(fake.commands, name+" "+strings.Join(args, " "))
+	return nil
+}
+
+func (fake *fakeModprobe) Output(name string, args ...string) (string, error) {
+	return "", fake.Run(name, args...)
+}
+
+/*
+Point the kmod paths at a temporary directory and replace the command runner, the returned function restores them
+*/
+func kmodSetup(state, module string, t *testing.T) (State, *fakeModprobe, func()) {
+	dir, err := ioutil.TempDir("", "otter-kmod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldProcModules, oldSysModule, oldModulesLoad, oldCommander := procModulesPath, sysModulePath, modulesLoadPath, commander
+	restore := func() {
+		procModulesPath, sysModulePath, modulesLoadPath, commander = oldProcModules, oldSysModule, oldModulesLoad, oldCommander
+		os.RemoveAll(dir)
+	}
+	procModulesPath = filepath.Join(dir, "modules")
+	sysModulePath = filepath.Join(dir, "sys")
+	modulesLoadPath = filepath.Join(dir, "modules-load.d")
+	fake := &fakeModprobe{}
+	commander = fake
+	ioutil.WriteFile(procModulesPath, procModules, 0644)
+	metadata := Metadata{Name: module, Type: "kmod", State: state}
+	return stateSetup(metadata, []byte(`{}`), t), fake, restore
+}
+
+func TestParseProcModules(t *testing.T) {
+	modules := parseProcModules(procModules)
+	if len(modules) != 3 || modules[0] != "br_netfilter" {
+		fmt.Println("Bad module list: ", modules)
+		t.Fail()
+	}
+}
+
+func TestKernelModuleNotPersisted(t *testing.T) {
+	state, fake, restore := kmodSetup("loaded", "br-netfilter", t)
+	defer restore()
+	result := state.State()
+	if result.Consistent {
+		fmt.Println("Failed to detect module not loaded at boot")
+		t.Fail()
+	}
+	result = state.Apply()
+	if !result.Consistent {
+		fmt.Println("Failed to persist module: ", result.Message)
+		t.Fail()
+	}
+	data, _ := ioutil.ReadFile(filepath.Join(modulesLoadPath, "br-netfilter.conf"))
+	if string(data) != "br-netfilter\n" {
+		fmt.Println("Bad modules-load.d file: ", string(data))
+		t.Fail()
+	}
+	if len(fake.commands) != 0 {
+		fmt.Println("Loaded module should not be loaded again: ", fake.commands)
+		t.Fail()
+	}
+}
+
+func TestKernelModuleLoad(t *testing.T) {
+	state, fake, restore := kmodSetup("loaded", "ip_vs", t)
+	defer restore()
+	if result := state.Apply(); !result.Consistent {
+		fmt.Println("Failed to load module: ", result.Message)
+		t.Fail()
+	}
+	if !reflect.DeepEqual(fake.commands, []string{"modprobe ip_vs"}) {
+		fmt.Println("Bad module commands: ", fake.commands)
+		t.Fail()
+	}
+}
+
+func TestKernelModuleAbsent(t *testing.T) {
+	state, _, restore := kmodSetup("absent", "ip_vs", t)
+	defer restore()
+	if !state.State().Consistent {
+		fmt.Println("Detected unloaded module as loaded")
+		t.Fail()
+	}
+}
+
+func TestKernelModuleRemove(t *testing.T) {
+	state, fake, restore := kmodSetup("absent", "overlay", t)
+	defer restore()
+	ioutil.WriteFile(filepath.Join(modulesLoadPath, "overlay.conf"), []byte("overlay\n"), 0644)
+	if result := state.Apply(); !result.Consistent {
+		fmt.Println("Failed to remove module: ", result.Message)
+		t.Fail()
+	}
+	if !reflect.DeepEqual(fake.commands, []string{"rmmod overlay"}) {
+		fmt.Println("Bad module commands: ", fake.commands)
+		t.Fail()
+	}
+	if _, err := os.Stat(filepath.Join(modulesLoadPath, "overlay.conf")); !os.IsNotExist(err) {
+		fmt.Println("Module is still loaded at boot")
+		t.Fail()
+	}
+}
@@ -0,0 +1,179 @@
+/*
+A Line represents a single line within a file which is otherwise not managed by Otter.
+States -
+  present: The line is present, lines matching the regular expression are replaced by it
+  absent: The line and any lines matching the regular expression are removed
+*/
+
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/vektorlab/otter/helpers"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+type Line struct {
+	Path     string   `json:"path"`   // File containing the line
+	Line     string   `json:"line"`   // Content of the line, may reference groups captured by match with $1, ${name}, etc.
+	Match    string   `json:"match"`  // Regular expression selecting the lines to replace or remove
+	Create   bool     `json:"create"` // Create the file if it does not exist
+	Metadata Metadata `json:"metadata"`
+	match    *regexp.Regexp
+}
+
+func (line *Line) Meta() Metadata {
+	return line.Metadata
+}
+
+func (line *Line) State() *Result {
+	result := &Result{
+		Metadata:   &line.Metadata,
+		Consistent: false,
+	}
+	content, err := readManagedFile(line.Path, line.Create)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	if diff := helpers.Diff(content, line.edit(content)); diff != "" {
+		result.Message = fmt.Sprintf("%s would change", line.Path)
+		result.Details = map[string]string{"diff": diff}
+		return result
+	}
+	result.Consistent = true
+	return result
+}
+
+func (line *Line) Apply() *Result {
+	result := line.State()
+	if result.Consistent == true {
+		return result
+	}
+	content, err := readManagedFile(line.Path, line.Create)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	err = writeManagedFile(line.Path, line.edit(content))
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	result.Message = fmt.Sprintf("%s updated", line.Path)
+	result.Consistent = true
+	return result
+}
+
+/*
+Create and validate a new Line State
+*/
+func newLine(metadata Metadata, data []byte) (*Line, error) {
+	line := &Line{}
+	err := json.Unmarshal(data, &line)
+	if err != nil {
+		return nil, err
+	}
+	line.Metadata = metadata
+	switch metadata.State {
+	case "present":
+		if line.Line == "" {
+			return nil, fmt.Errorf("No line specified for %s", metadata.Name)
+		}
+	case "absent":
+		if line.Line == "" && line.Match == "" {
+			return nil, fmt.Errorf("A line or match is required for %s", metadata.Name)
+		}
+	default:
+		return nil, fmt.Errorf("Invalid line state: %s", metadata.State)
+	}
+	if line.Path == "" {
+		return nil, fmt.Errorf("No path specified for %s", metadata.Name)
+	}
+	if strings.Contains(line.Line, "\n") {
+		return nil, fmt.Errorf("Line for %s must not contain a newline", metadata.Name)
+	}
+	if line.Match != "" {
+		line.match, err = regexp.Compile(line.Match)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return line, nil
+}
+
+/*
+Return the content of the file with the line applied
+*/
+func (line *Line) edit(content string) string {
+	lines := splitFileLines(content)
+	edited := make([]string, 0)
+	found := false
+	for _, l := range lines {
+		matched := line.Line != "" && l == line.Line // An empty line only removes lines matching match
+		replacement := line.Line
+		if line.match != nil {
+			if idx := line.match.FindStringSubmatchIndex(l); idx != nil {
+				matched = true
+				replacement = string(line.match.ExpandString(nil, line.Line, l, idx))
+			}
+		}
+		if !matched {
+			edited = append(edited, l)
+			continue
+		}
+		if line.Metadata.State == "present" && !found {
+			edited = append(edited, replacement)
+			found = true
+		}
+	}
+	if line.Metadata.State == "present" && !found {
+		edited = append(edited, line.Line)
+	}
+	return joinFileLines(edited)
+}
+
+/*
+Read a file which is partially managed by Otter, a missing file is empty if it may be created
+*/
+func readManagedFile(path string, create bool) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && create {
+			return "", nil
+		}
+		return "", err
+	}
+	return string(data), nil
+}
+
+/*
+Write a file which is partially managed by Otter, keeping the mode of an existing file
+*/
+func writeManagedFile(path, content string) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode()
+	}
+	log.Printf("Writing to file [%s] %s", mode, path)
+	return ioutil.WriteFile(path, []byte(content), mode)
+}
+
+func splitFileLines(content string) []string {
+	if content == "" {
+		return []string{}
+	}
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
+
+func joinFileLines(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,98 @@
+package state
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var grubDefault = `GRUB_DEFAULT=0
+GRUB_TIMEOUT=5
+GRUB_CMDLINE_LINUX="quiet"
+`
+
+func lineSetup(state, data, content string, t *testing.T) (State, string) {
+	dir, err := ioutil.TempDir("", "otter-line")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "grub")
+	ioutil.WriteFile(path, []byte(content), 0640)
+	metadata := Metadata{Name: "grub cmdline", Type: "line", State: state}
+	return stateSetup(metadata, []byte(fmt.Sprintf(`{"path": "%s", %s}`, path, data)), t), path
+}
+
+func TestLineReplace(t *testing.T) {
+	state, path := lineSetup("present", `"match": "^GRUB_CMDLINE_LINUX=\"(.*)\"$", "line": "GRUB_CMDLINE_LINUX=\"$1 cgroup_enable=memory\""`, grubDefault, t)
+	defer os.RemoveAll(filepath.Dir(path))
+	result := state.State()
+	if result.Consistent || result.Details["diff"] != "-GRUB_CMDLINE_LINUX=\"quiet\"\n+GRUB_CMDLINE_LINUX=\"quiet cgroup_enable=memory\"" {
+		fmt.Println("Bad line diff: ", result.Details["diff"])
+		t.Fail()
+	}
+	state.Apply()
+	data, _ := ioutil.ReadFile(path)
+	if string(data) != "GRUB_DEFAULT=0\nGRUB_TIMEOUT=5\nGRUB_CMDLINE_LINUX=\"quiet cgroup_enable=memory\"\n" {
+		fmt.Println("Bad line replacement: ", string(data))
+		t.Fail()
+	}
+	info, _ := os.Stat(path)
+	if info.Mode().Perm() != 0640 {
+		fmt.Println("File mode was not preserved: ", info.Mode())
+		t.Fail()
+	}
+}
+
+func TestLineAppend(t *testing.T) {
+	state, path := lineSetup("present", `"line": "GRUB_TERMINAL=console"`, grubDefault, t)
+	defer os.RemoveAll(filepath.Dir(path))
+	state.Apply()
+	if !state.State().Consistent {
+		fmt.Println("Line not consistent after apply")
+		t.Fail()
+	}
+	data, _ := ioutil.ReadFile(path)
+	if string(data) != grubDefault+"GRUB_TERMINAL=console\n" {
+		fmt.Println("Bad appended line: ", string(data))
+		t.Fail()
+	}
+}
+
+func TestLineAbsent(t *testing.T) {
+	state, path := lineSetup("absent", `"match": "^GRUB_TIMEOUT="`, grubDefault, t)
+	defer os.RemoveAll(filepath.Dir(path))
+	result := state.Apply()
+	data, _ := ioutil.ReadFile(path)
+	if !result.Consistent || string(data) != "GRUB_DEFAULT=0\nGRUB_CMDLINE_LINUX=\"quiet\"\n" {
+		fmt.Println("Failed to remove line: ", string(data))
+		t.Fail()
+	}
+}
+
+func TestLineAbsentBlankLines(t *testing.T) {
+	state, path := lineSetup("absent", `"match": "^foo="`, "a\n\nfoo=1\n\nb\n", t)
+	defer os.RemoveAll(filepath.Dir(path))
+	result := state.Apply()
+	data, _ := ioutil.ReadFile(path)
+	if !result.Consistent || string(data) != "a\n\n\nb\n" {
+		fmt.Printf("Blank lines were removed with the matching line: %q\n", string(data))
+		t.Fail()
+	}
+}
+
+func TestLineMissingFile(t *testing.T) {
+	state, path := lineSetup("present", `"line": "127.0.0.1 localhost"`, "", t)
+	defer os.RemoveAll(filepath.Dir(path))
+	os.Remove(path)
+	if result := state.Apply(); result.Consistent {
+		fmt.Println("Created file without create flag")
+		t.Fail()
+	}
+	state.(*Line).Create = true
+	if result := state.Apply(); !result.Consistent {
+		fmt.Println("Failed to create file: ", result.Message)
+		t.Fail()
+	}
+}
@@ -0,0 +1,440 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+/*
+A yamlLoader loads states from YAML documents which may include other documents.
+States are kept in the order they are declared, included files are loaded where the include directive appears.
+Every document is read before any state is created so variables may be declared in any file.
+*/
+type yamlLoader struct {
+	loading      map[string]bool   // Files currently being loaded, used to detect include cycles
+	declared     map[string]string // Location where each state name was first declared
+	declarations []yamlDeclaration
+	targets      []yamlTarget
+	vars         Vars
+	invalid      ValidationErrors // Problems found while creating states, every state is created so all are reported
+}
+
+/*
+A yamlDeclaration is a state name and its keywords along with the file it was read from
+*/
+type yamlDeclaration struct {
+	file    string
+	key     *yaml.Node
+	value   *yaml.Node
+	forEach *yaml.Node // List or map the declaration is expanded over, nil if it declares a single name
+	vars    Vars       // Variables substituted into the states, including the item of an expanded declaration
+}
+
+/*
+A yamlTarget is a target along with the file it was read from
+*/
+type yamlTarget struct {
+	file   string
+	node   *yaml.Node
+	target *Target
+}
+
+func newYamlLoader() *yamlLoader {
+	return &yamlLoader{
+		loading:      make(map[string]bool),
+		declared:     make(map[string]string),
+		declarations: make([]yamlDeclaration, 0),
+		targets:      make([]yamlTarget, 0),
+		vars:         make(Vars),
+		invalid:      make(ValidationErrors, 0),
+	}
+}
+
+/*
+Create every declared state and return a StateMap of them, overrides take precedence over variables in the documents
+*/
+func (loader *yamlLoader) stateMap(overrides Vars) (*StateMap, error) {
+	vars := make(Vars)
+	vars.Merge(loader.vars)
+	vars.Merge(overrides)
+	states := make([]State, 0)
+	for _, declaration := range loader.declarations {
+		expanded, err := loader.expand(declaration, vars)
+		if err != nil {
+			loader.invalid = append(loader.invalid, err.(*ValidationError))
+			continue
+		}
+		for _, declaration := range expanded {
+			created, err := loader.loadStates(declaration, declaration.vars)
+			if invalid, ok := err.(*ValidationError); ok {
+				loader.invalid = append(loader.invalid, invalid)
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			states = append(states, created...)
+		}
+	}
+	if len(loader.invalid) > 0 {
+		return nil, loader.invalid
+	}
+	sm := NewStateMap()
+	sm.Vars = vars
+	for _, target := range loader.targets {
+		for _, name := range target.target.States {
+			if _, exists := loader.declared[name]; !exists {
+				return nil, loader.errorf(target.file, target.node, "Target %s references unknown state %s", target.target.Name, name)
+			}
+		}
+		sm.Targets = append(sm.Targets, target.target)
+	}
+	err := sm.AddMany(states, 0, len(states))
+	return sm, err
+}
+
+/*
+Load a YAML file, include paths are relative to the directory of the file
+*/
+func (loader *yamlLoader) loadFile(path string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if loader.loading[path] {
+		return fmt.Errorf("Detected include cycle: %s includes itself", path)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	loader.loading[path] = true
+	defer delete(loader.loading, path)
+	return loader.load(data, path)
+}
+
+/*
+Load a YAML document read from file, an empty file name is used for documents which were not read from disk
+*/
+func (loader *yamlLoader) load(data []byte, file string) error {
+	root := &yaml.Node{}
+	err := yaml.Unmarshal(data, root)
+	if err != nil {
+		if file != "" {
+			return fmt.Errorf("%s: %s", file, err)
+		}
+		return err
+	}
+	if len(root.Content) == 0 {
+		return nil // Empty document
+	}
+	document := root.Content[0]
+	if document.Kind != yaml.MappingNode {
+		return loader.errorf(file, document, "Expected a mapping of state names")
+	}
+	for i := 0; i < len(document.Content); i += 2 {
+		key, value := document.Content[i], document.Content[i+1]
+		switch key.Value {
+		case "include":
+			err = loader.include(file, value)
+		case "vars":
+			err = loader.loadVars(file, value)
+		case "targets":
+			err = loader.loadTargets(file, value)
+		default:
+			err = loader.declare(file, key, value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+Load the files referenced by an include directive, each entry is a path or a glob pattern
+*/
+func (loader *yamlLoader) include(file string, value *yaml.Node) error {
+	entries := []*yaml.Node{value}
+	if value.Kind == yaml.SequenceNode {
+		entries = value.Content
+	}
+	dir := "."
+	if file != "" {
+		dir = filepath.Dir(file)
+	}
+	for _, entry := range entries {
+		if entry.Kind != yaml.ScalarNode || entry.Value == "" {
+			return loader.errorf(file, entry, "Expected a path to include")
+		}
+		pattern := entry.Value
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return loader.errorf(file, entry, "Invalid include pattern %s: %s", entry.Value, err)
+		}
+		if len(matches) == 0 && !strings.ContainsAny(entry.Value, "*?[") {
+			return loader.errorf(file, entry, "Included file %s does not exist", entry.Value)
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			err = loader.loadFile(match)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/*
+Record the keywords declared under a state name, names must be unique across every loaded document
+*/
+func (loader *yamlLoader) declare(file string, key, value *yaml.Node) error {
+	if first, exists := loader.declared[key.Value]; exists {
+		return loader.errorf(file, key, "Detected duplicate state %s, first declared at %s", key.Value, first)
+	}
+	loader.declared[key.Value] = loader.location(file, key)
+	if value.Kind != yaml.MappingNode && value.Kind != yaml.SequenceNode {
+		return loader.errorf(file, value, "Expected a mapping or list of keywords for state %s", key.Value)
+	}
+	declaration := yamlDeclaration{file: file, key: key, value: value}
+	if value.Kind == yaml.MappingNode {
+		keywords := *value
+		keywords.Content = make([]*yaml.Node, 0)
+		for i := 0; i < len(value.Content); i += 2 {
+			if value.Content[i].Value == "for_each" {
+				declaration.forEach = value.Content[i+1]
+				continue
+			}
+			keywords.Content = append(keywords.Content, value.Content[i], value.Content[i+1])
+		}
+		declaration.value = &keywords
+	}
+	loader.declarations = append(loader.declarations, declaration)
+	return nil
+}
+
+/*
+Expand a declaration with for_each into a declaration for each item of a list or map, the item is available to
+templates as .item along with .index for lists or .key and .value for maps. Names which do not reference the item are
+suffixed with the index or key so every expanded state is uniquely named.
+*/
+func (loader *yamlLoader) expand(declaration yamlDeclaration, vars Vars) ([]yamlDeclaration, error) {
+	if declaration.forEach == nil {
+		declaration.vars = vars
+		return []yamlDeclaration{declaration}, nil
+	}
+	file, name, node := declaration.file, declaration.key.Value, declaration.forEach
+	var items interface{}
+	if node.Kind == yaml.ScalarNode {
+		value, exists := vars[strings.TrimPrefix(node.Value, "vars.")]
+		if !exists {
+			return nil, loader.errorf(file, node, "Undefined variable %s in for_each of state %s", node.Value, name)
+		}
+		items = value
+	} else {
+		substituted, failed, err := substituteVars(node, vars)
+		if err != nil {
+			return nil, loader.errorf(file, failed, "%s", err)
+		}
+		err = substituted.Decode(&items)
+		if err != nil {
+			return nil, loader.errorf(file, node, "%s", err)
+		}
+	}
+	if nested, ok := items.(Vars); ok {
+		items = map[string]interface{}(nested) // Maps nested in variables are decoded as Vars
+	}
+	iterations := make([]Vars, 0)
+	suffixes := make([]string, 0)
+	switch items := items.(type) {
+	case []interface{}:
+		for i, item := range items {
+			iterations = append(iterations, Vars{"item": item, "index": i})
+			suffixes = append(suffixes, fmt.Sprint(i))
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0)
+		for key := range items {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			iterations = append(iterations, Vars{"item": items[key], "key": key, "value": items[key]})
+			suffixes = append(suffixes, key)
+		}
+	default:
+		return nil, loader.errorf(file, node, "Expected a list or map to expand state %s over", name)
+	}
+	expanded := make([]yamlDeclaration, 0)
+	for i, iteration := range iterations {
+		itemVars := make(Vars)
+		itemVars.Merge(vars)
+		itemVars.Merge(iteration)
+		key, failed, err := substituteVars(declaration.key, itemVars)
+		if err != nil {
+			return nil, loader.errorf(file, failed, "%s", err)
+		}
+		if key.Value == name {
+			key.Value = fmt.Sprintf("%s-%s", name, suffixes[i])
+		}
+		if first, exists := loader.declared[key.Value]; exists {
+			return nil, loader.errorf(file, declaration.key, "Detected duplicate state %s expanding %s, first declared at %s", key.Value, name, first)
+		}
+		loader.declared[key.Value] = loader.location(file, declaration.key)
+		expanded = append(expanded, yamlDeclaration{file: file, key: key, value: declaration.value, vars: itemVars})
+	}
+	return expanded, nil
+}
+
+/*
+Return the keywords declared under a name and their data, keywords are either a mapping or a list of single keyword
+mappings which allows a keyword to be repeated
+*/
+func (loader *yamlLoader) keywords(declaration yamlDeclaration) ([]*yaml.Node, error) {
+	if declaration.value.Kind == yaml.MappingNode {
+		return declaration.value.Content, nil
+	}
+	keywords := make([]*yaml.Node, 0)
+	for _, item := range declaration.value.Content {
+		if item.Kind != yaml.MappingNode || len(item.Content) != 2 {
+			return nil, loader.errorf(declaration.file, item, "Expected a single keyword in the list of state %s", declaration.key.Value)
+		}
+		keywords = append(keywords, item.Content...)
+	}
+	return keywords, nil
+}
+
+/*
+Create the states declared under a name, each keyword is a "type.state" pair
+*/
+func (loader *yamlLoader) loadStates(declaration yamlDeclaration, vars Vars) ([]State, error) {
+	file, name := declaration.file, declaration.key.Value
+	keywords, err := loader.keywords(declaration)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0)
+	for i := 0; i < len(keywords); i += 2 {
+		names = append(names, keywords[i].Value)
+	}
+	ids := stateIDs(name, names)
+	states := make([]State, 0)
+	for i := 0; i < len(keywords); i += 2 {
+		keyword, data := keywords[i], keywords[i+1]
+		split := strings.Split(keyword.Value, ".")
+		if len(split) != 2 {
+			return nil, loader.errorf(file, keyword, "Invalid keyword %s, expected <type>.<state>", keyword.Value)
+		}
+		data, failed, err := substituteVars(data, vars)
+		if err != nil {
+			return nil, loader.errorf(file, failed, "%s", err)
+		}
+		keepStrings(split[0], data)
+		raw, err := yamlNodeToJson(data)
+		if err != nil {
+			return nil, loader.errorf(file, data, "%s", err)
+		}
+		metadata := Metadata{ID: ids[i/2], Name: name, Type: split[0], State: split[1], File: file, Line: keyword.Line}
+		state, err := StateFactory(metadata, raw)
+		if err != nil {
+			return nil, loader.errorf(file, keyword, "%s", err)
+		}
+		loader.checkKeys(file, data, state)
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+/*
+Record an error for every key in the data of a state which is not accepted by its type
+*/
+func (loader *yamlLoader) checkKeys(file string, data *yaml.Node, state State) {
+	if data.Kind != yaml.MappingNode {
+		return
+	}
+	keys := stateKeys(state)
+	for i := 0; i < len(data.Content); i += 2 {
+		key := data.Content[i]
+		if unknown, suggestion := unknownKey(key.Value, keys); unknown {
+			metadata := state.Meta()
+			loader.invalid = append(loader.invalid, loader.errorf(file, key, "Unknown key %s in %s.%s%s", key.Value, metadata.Type, metadata.State, didYouMean(suggestion)))
+		}
+	}
+}
+
+/*
+Load the targets of a targets: section, each target is named
+*/
+func (loader *yamlLoader) loadTargets(file string, value *yaml.Node) error {
+	if value.Kind != yaml.MappingNode {
+		return loader.errorf(file, value, "Expected a mapping of targets")
+	}
+	for i := 0; i < len(value.Content); i += 2 {
+		key, node := value.Content[i], value.Content[i+1]
+		target := &Target{}
+		err := node.Decode(target)
+		if err != nil {
+			return loader.errorf(file, node, "%s", err)
+		}
+		target.Name = key.Value
+		err = target.compile()
+		if err != nil {
+			return loader.errorf(file, node, "%s", err)
+		}
+		loader.targets = append(loader.targets, yamlTarget{file: file, node: key, target: target})
+	}
+	return nil
+}
+
+func (loader *yamlLoader) location(file string, node *yaml.Node) string {
+	if file == "" {
+		return fmt.Sprintf("line %d", node.Line)
+	}
+	return fmt.Sprintf("%s:%d", file, node.Line)
+}
+
+/*
+Return an error prefixed with the file and line of a node
+*/
+func (loader *yamlLoader) errorf(file string, node *yaml.Node, format string, args ...interface{}) *ValidationError {
+	return &ValidationError{File: file, Line: node.Line, Message: fmt.Sprintf(format, args...)}
+}
+
+/*
+Tag the values of string fields as strings so substituted values such as "0" are not decoded as numbers
+*/
+func keepStrings(stateType string, data *yaml.Node) {
+	if data.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(data.Content); i += 2 {
+		value := data.Content[i+1]
+		if value.Kind == yaml.ScalarNode && stateFieldKind(stateType, data.Content[i].Value) == reflect.String {
+			value.Tag = "!!str"
+		}
+	}
+}
+
+/*
+Convert a YAML node to JSON, an empty node is an empty object
+*/
+func yamlNodeToJson(node *yaml.Node) ([]byte, error) {
+	var value interface{}
+	err := node.Decode(&value)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return []byte(`{}`), nil
+	}
+	return json.Marshal(value)
+}
@@ -0,0 +1,154 @@
+package state
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func includeSetup(files map[string]string, t *testing.T) string {
+	dir, err := ioutil.TempDir("", "otter-include")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		ioutil.WriteFile(path, []byte(content), 0644)
+	}
+	return dir
+}
+
+func TestInclude(t *testing.T) {
+	dir := includeSetup(map[string]string{
+		"otter.yaml": `
+include:
+  - docker.yaml
+  - fragments/*.yaml
+kubelet:
+  package.installed:
+    require:
+      - docker
+`,
+		"docker.yaml": `
+docker:
+  package.installed:
+    version: 1.12.6
+`,
+		"fragments/a.yaml": `
+net.ipv4.ip_forward:
+  sysctl.present:
+    value: "1"
+`,
+		"fragments/b.yaml": `
+include: ../common/*.yaml
+`,
+	}, t)
+	defer os.RemoveAll(dir)
+	stateMap, err := StateMapFromYamlPath(filepath.Join(dir, "otter.yaml"))
+	if err != nil {
+		fmt.Println("Failed to load included states: ", err)
+		t.FailNow()
+	}
+	names := make([]string, 0)
+	for _, state := range stateMap.States {
+		names = append(names, state.Meta().Name)
+	}
+	if strings.Join(names, ",") != "docker,net.ipv4.ip_forward,kubelet" {
+		fmt.Println("Bad included states: ", names)
+		t.Fail()
+	}
+}
+
+func TestIncludeDuplicate(t *testing.T) {
+	dir := includeSetup(map[string]string{
+		"otter.yaml":  "include: docker.yaml\ndocker:\n  service.running: {}\n",
+		"docker.yaml": "\ndocker:\n  package.installed: {}\n",
+	}, t)
+	defer os.RemoveAll(dir)
+	_, err := StateMapFromYamlPath(filepath.Join(dir, "otter.yaml"))
+	expected := fmt.Sprintf("%s:2: Detected duplicate state docker, first declared at %s:2", filepath.Join(dir, "otter.yaml"), filepath.Join(dir, "docker.yaml"))
+	if err == nil || err.Error() != expected {
+		fmt.Println("Failed to detect duplicate state: ", err)
+		t.Fail()
+	}
+}
+
+func TestIncludeErrors(t *testing.T) {
+	dir := includeSetup(map[string]string{
+		"cycle.yaml":   "include: loop.yaml\n",
+		"loop.yaml":    "include: cycle.yaml\n",
+		"missing.yaml": "docker:\n  package.installed: {}\ninclude: kubelet.yaml\n",
+		"invalid.yaml": "docker:\n  package.installed:\n    version: 1.12.6\n  package: {}\n",
+	}, t)
+	defer os.RemoveAll(dir)
+	for file, expected := range map[string]string{
+		"cycle.yaml":   "Detected include cycle",
+		"missing.yaml": "missing.yaml:3: Included file kubelet.yaml does not exist",
+		"invalid.yaml": "invalid.yaml:4: Invalid keyword package",
+	} {
+		_, err := StateMapFromYamlPath(filepath.Join(dir, file))
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			fmt.Println("Bad include error: ", err)
+			t.Fail()
+		}
+	}
+}
+
+var looped = []byte(`
+vars:
+  users:
+    - name: alice
+      shell: /bin/zsh
+    - name: bob
+      shell: /bin/bash
+  sysctls:
+    net.ipv4.ip_forward: "1"
+    vm.swappiness: "10"
+"{{ .item.name }}":
+  for_each: users
+  user.present:
+    shell: "{{ .item.shell }}"
+"{{ .key }}":
+  for_each: vars.sysctls
+  sysctl.present:
+    value: "{{ .value }}"
+docker-group:
+  for_each: [docker, wheel]
+  group.present:
+    groupname: "{{ .item }}"
+`)
+
+func TestForEach(t *testing.T) {
+	stateMap := loadStateMapFromYaml(looped, t)
+	names := make([]string, 0)
+	for _, state := range stateMap.States {
+		names = append(names, state.Meta().Name)
+	}
+	if strings.Join(names, ",") != "alice,bob,net.ipv4.ip_forward,vm.swappiness,docker-group-0,docker-group-1" {
+		fmt.Println("Bad expanded states: ", names)
+		t.FailNow()
+	}
+	if user := stateMap.States[1].(*User); user.Shell != "/bin/bash" {
+		fmt.Println("Item was not substituted into expanded state: ", user.Shell)
+		t.Fail()
+	}
+	if sysctl := stateMap.States[3].(*Sysctl); sysctl.Value != "10" {
+		fmt.Println("Map value was not substituted into expanded state: ", sysctl.Value)
+		t.Fail()
+	}
+	for data, expected := range map[string]string{
+		"docker:\n  for_each: missing\n  package.installed: {}\n":                                         "line 2: Undefined variable missing in for_each of state docker",
+		"vars:\n  v: x\ndocker:\n  for_each: v\n  package.installed: {}\n":                                "line 4: Expected a list or map to expand state docker over",
+		"docker:\n  package.installed: {}\nd{{ .item }}:\n  for_each: [ocker]\n  package.installed: {}\n": "line 3: Detected duplicate state docker expanding d{{ .item }}, first declared at line 1",
+	} {
+		_, err := StateMapFromYaml([]byte(data))
+		if err == nil || err.Error() != expected {
+			fmt.Println("Bad for_each error: ", err)
+			t.Fail()
+		}
+	}
+}
@@ -1,18 +1,61 @@
 package state
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+)
 
 type Metadata struct {
+	ID    string // Unique identifier of the state, "<name>:<type>.<state>" with a ":<n>" suffix on repeats of a keyword
 	Name  string // Unique name to associate with a state
 	Type  string // The type of state "package", "file", etc.
 	State string // The desired state "installed", "rendered", etc.
 	Requirements []string `json:"require"`// List of dependent states.
+	When  string `json:"when,omitempty"` // Expression of host facts and variables deciding if the state applies
+	File  string `json:",omitempty"` // File the state was declared in
+	Line  int    `json:",omitempty"` // Line the state was declared on
 }
 
+/*
+Compare two states by their IDs, states loaded without IDs are compared by name, type and state
+*/
 func (md *Metadata) Equal(metadata *Metadata) bool {
+	if metadata.ID != "" && md.ID != "" {
+		return metadata.ID == md.ID
+	}
 	return metadata.Name == md.Name && metadata.Type == md.Type && metadata.State == md.State
 }
 
+/*
+Check if a requirement, either a state name or ID, refers to this state
+*/
+func (md *Metadata) Satisfies(requirement string) bool {
+	return md.Name == requirement || (md.ID != "" && md.ID == requirement)
+}
+
+/*
+Return the position a state was declared at, "<file>:<line>" or "line <n>" when it was not read from a file
+*/
+func (md *Metadata) Location() string {
+	switch {
+	case md.Line == 0:
+		return ""
+	case md.File == "":
+		return fmt.Sprintf("line %d", md.Line)
+	}
+	return fmt.Sprintf("%s:%d", md.File, md.Line)
+}
+
+/*
+Return an error prefixed with the position the state was declared at, if it is known
+*/
+func (md *Metadata) Errorf(format string, args ...interface{}) error {
+	if md.Line == 0 {
+		return fmt.Errorf(format, args...)
+	}
+	return &ValidationError{File: md.File, Line: md.Line, Message: fmt.Sprintf(format, args...)}
+}
+
 func MetadataFromJSON(data json.RawMessage) (Metadata, error) {
 	metadata := Metadata{}
 	raw := make(map[string]json.RawMessage)
@@ -30,3 +73,21 @@ func MetadataFromJSON(data json.RawMessage) (Metadata, error) {
 	}
 	return metadata, nil
 }
+
+/*
+Generate the IDs of the states declared under a name in the order they appear, "<name>:<type>.<state>" for the first
+occurrence of a keyword and later repeats are numbered from 2 so adding a repeat does not renumber existing IDs
+*/
+func stateIDs(name string, keywords []string) []string {
+	seen := make(map[string]int)
+	ids := make([]string, 0)
+	for _, keyword := range keywords {
+		seen[keyword]++
+		if seen[keyword] > 1 {
+			ids = append(ids, fmt.Sprintf("%s:%s:%d", name, keyword, seen[keyword]))
+		} else {
+			ids = append(ids, fmt.Sprintf("%s:%s", name, keyword))
+		}
+	}
+	return ids
+}
@@ -0,0 +1,363 @@
+/*
+A Mount represents a filesystem mounted on an operating system and its entry in /etc/fstab.
+States -
+  mounted: The filesystem is mounted with the declared options and present in /etc/fstab
+  unmounted: The filesystem is not mounted but is present in /etc/fstab
+  absent: The filesystem is not mounted and is not present in /etc/fstab
+*/
+
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var mountInfoPath = "/proc/self/mountinfo"
+
+// Options which are never shown for a mounted filesystem: defaults implied when a flag is not set, options which only
+// affect how fstab is processed and bind mounts. rw and ro are always shown and compared explicitly
+var mountDefaults = []string{"suid", "dev", "exec", "async", "nomand", "atime", "diratime", "defaults", "auto", "noauto", "user", "nouser", "users", "nofail", "_netdev", "owner", "group", "bind", "rbind"}
+
+type Mount struct {
+	Device   string   `json:"device"`  // Block device, UUID=, LABEL= or remote source to mount
+	Path     string   `json:"path"`    // Mount point, defaults to the state name
+	FSType   string   `json:"fstype"`  // Filesystem type
+	Options  string   `json:"options"` // Comma separated mount options
+	Dump     int      `json:"dump"`    // fstab dump field
+	Pass     int      `json:"pass"`    // fstab fsck pass number
+	Metadata Metadata `json:"metadata"`
+}
+
+type mountInfo struct {
+	Path    string
+	Device  string
+	FSType  string
+	Options []string // Per mount and super block options combined
+}
+
+func (mount *Mount) Meta() Metadata {
+	return mount.Metadata
+}
+
+func (mount *Mount) State() *Result {
+	result := &Result{
+		Metadata:   &mount.Metadata,
+		Consistent: false,
+	}
+	info, mounted, err := mount.mounted()
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	lines, err := readFstab()
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	entry, inFstab := findFstabEntry(lines, mount.Path)
+	switch mount.Metadata.State {
+	case "mounted":
+		if !mounted {
+			result.Message = fmt.Sprintf("%s is not mounted", mount.Path)
+			return result
+		}
+		if !mount.mountedFrom(info) {
+			result.Message = fmt.Sprintf("%s is mounted from %s, expected %s", mount.Path, info.Device, mount.Device)
+			return result
+		}
+		if missing := mount.missingOptions(info); len(missing) > 0 {
+			result.Message = fmt.Sprintf("%s is missing mount options: %s", mount.Path, strings.Join(missing, ","))
+			return result
+		}
+		if !inFstab || entry != mount.fstabEntry() {
+			result.Message = fmt.Sprintf("fstab entry for %s does not match", mount.Path)
+			return result
+		}
+	case "unmounted":
+		if mounted {
+			result.Message = fmt.Sprintf("%s is mounted", mount.Path)
+			return result
+		}
+		if !inFstab || entry != mount.fstabEntry() {
+			result.Message = fmt.Sprintf("fstab entry for %s does not match", mount.Path)
+			return result
+		}
+	case "absent":
+		if mounted {
+			result.Message = fmt.Sprintf("%s is mounted", mount.Path)
+			return result
+		}
+		if inFstab {
+			result.Message = fmt.Sprintf("%s is present in fstab", mount.Path)
+			return result
+		}
+	}
+	result.Consistent = true
+	return result
+}
+
+func (mount *Mount) Apply() *Result {
+	result := mount.State()
+	if result.Consistent == true {
+		return result
+	}
+	info, mounted, err := mount.mounted()
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	switch mount.Metadata.State {
+	case "mounted":
+		err = mount.updateFstab(false)
+		if err == nil {
+			switch {
+			case mounted && !mount.mountedFrom(info):
+				err = unmountFilesystem(mount.Path)
+				if err == nil {
+					err = mount.mount(false)
+				}
+			case mounted:
+				err = mount.mount(true)
+			default:
+				err = mount.mount(false)
+			}
+		}
+		result.Message = "Filesystem mounted"
+	case "unmounted":
+		err = mount.updateFstab(false)
+		if err == nil && mounted {
+			err = unmountFilesystem(mount.Path)
+		}
+		result.Message = "Filesystem unmounted"
+	case "absent":
+		if mounted {
+			err = unmountFilesystem(mount.Path)
+		}
+		if err == nil {
+			err = mount.updateFstab(true)
+		}
+		result.Message = "Filesystem removed"
+	}
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	result.Consistent = true
+	return result
+}
+
+/*
+Create and validate a new Mount State
+*/
+func newMount(metadata Metadata, data []byte) (*Mount, error) {
+	mount := &Mount{}
+	err := json.Unmarshal(data, &mount)
+	if err != nil {
+		return nil, err
+	}
+	mount.Metadata = metadata
+	switch metadata.State {
+	case "mounted":
+	case "unmounted":
+	case "absent":
+	default:
+		return nil, fmt.Errorf("Invalid mount state: %s", metadata.State)
+	}
+	if mount.Path == "" {
+		mount.Path = metadata.Name
+	}
+	mount.Path = filepath.Clean(mount.Path)
+	if !filepath.IsAbs(mount.Path) {
+		return nil, fmt.Errorf("Mount point must be an absolute path: %s", mount.Path)
+	}
+	if mount.Options == "" {
+		mount.Options = "defaults"
+	}
+	if metadata.State != "absent" && (mount.Device == "" || mount.FSType == "") {
+		return nil, fmt.Errorf("A device and fstype are required to mount %s", mount.Path)
+	}
+	return mount, nil
+}
+
+/*
+Return the fstab entry declared by this state
+*/
+func (mount *Mount) fstabEntry() fstabEntry {
+	return fstabEntry{
+		Device:  mount.Device,
+		Path:    mount.Path,
+		FSType:  mount.FSType,
+		Options: mount.Options,
+		Dump:    strconv.Itoa(mount.Dump),
+		Pass:    strconv.Itoa(mount.Pass),
+	}
+}
+
+/*
+Add, replace or remove the fstab entry for this mount point
+*/
+func (mount *Mount) updateFstab(remove bool) error {
+	lines, err := readFstab()
+	if err != nil {
+		return err
+	}
+	entry := mount.fstabEntry()
+	if remove {
+		return writeFstab(updateFstabEntry(lines, mount.Path, nil))
+	}
+	if current, ok := findFstabEntry(lines, mount.Path); ok && current == entry {
+		return nil
+	}
+	return writeFstab(updateFstabEntry(lines, mount.Path, &entry))
+}
+
+/*
+Mount the filesystem, creating the mount point if needed
+*/
+func (mount *Mount) mount(remount bool) error {
+	if !remount {
+		err := os.MkdirAll(mount.Path, 0755)
+		if err != nil {
+			return err
+		}
+	}
+	device, err := resolveDevice(mount.Device)
+	if err != nil {
+		return err
+	}
+	log.Printf("Mounting %s on %s (%s) with options %s", device, mount.Path, mount.FSType, mount.Options)
+	return mountFilesystem(device, mount.Path, mount.FSType, mount.Options, remount)
+}
+
+/*
+Find the filesystem currently mounted at this mount point
+*/
+func (mount *Mount) mounted() (mountInfo, bool, error) {
+	data, err := ioutil.ReadFile(mountInfoPath)
+	if err != nil {
+		return mountInfo{}, false, err
+	}
+	mounts, err := parseMountInfo(data)
+	if err != nil {
+		return mountInfo{}, false, err
+	}
+	info, found := mountInfo{}, false
+	for _, m := range mounts {
+		if m.Path == mount.Path {
+			info, found = m, true // The last mount on a path hides the others
+		}
+	}
+	return info, found, nil
+}
+
+/*
+List the declared options which are not in effect on the mounted filesystem
+*/
+func (mount *Mount) missingOptions(info mountInfo) []string {
+	wanted := make([]string, 0)
+	readOnly := false
+	for _, option := range strings.Split(mount.Options, ",") {
+		if option == "" || strings.HasPrefix(option, "x-") || strings.HasPrefix(option, "comment=") {
+			continue
+		}
+		ignore := option == "rw"
+		for _, o := range mountDefaults {
+			if option == o {
+				ignore = true
+			}
+		}
+		if option == "ro" {
+			readOnly = true
+		}
+		if !ignore {
+			wanted = append(wanted, option)
+		}
+	}
+	if !readOnly {
+		wanted = append(wanted, "rw") // A filesystem is mounted read-write unless ro is declared
+	}
+	return missingOptions(info.Options, wanted)
+}
+
+/*
+Check the filesystem is mounted from the declared device, the device of a bind mount is the filesystem containing the
+bound directory so it is not compared
+*/
+func (mount *Mount) mountedFrom(info mountInfo) bool {
+	for _, option := range strings.Split(mount.Options, ",") {
+		if option == "bind" || option == "rbind" {
+			return true
+		}
+	}
+	return sameDevice(info.Device, mount.Device)
+}
+
+/*
+Parse the contents of /proc/self/mountinfo
+*/
+func parseMountInfo(data []byte) ([]mountInfo, error) {
+	mounts := make([]mountInfo, 0)
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		separator := -1
+		for i, field := range fields {
+			if field == "-" && i >= 6 {
+				separator = i
+				break
+			}
+		}
+		if separator == -1 || len(fields) < separator+3 {
+			return nil, fmt.Errorf("Malformed mountinfo entry: %s", line)
+		}
+		options := strings.Split(fields[5], ",")
+		if len(fields) > separator+3 {
+			options = append(options, strings.Split(fields[separator+3], ",")...)
+		}
+		mounts = append(mounts, mountInfo{
+			Path:    unescapeMountPath(fields[4]),
+			FSType:  fields[separator+1],
+			Device:  unescapeMountPath(fields[separator+2]),
+			Options: options,
+		})
+	}
+	return mounts, nil
+}
+
+/*
+Resolve UUID= and LABEL= device specifications and symbolic links to a device path
+*/
+func resolveDevice(device string) (string, error) {
+	switch {
+	case strings.HasPrefix(device, "UUID="):
+		return filepath.EvalSymlinks(filepath.Join("/dev/disk/by-uuid", strings.TrimPrefix(device, "UUID=")))
+	case strings.HasPrefix(device, "LABEL="):
+		return filepath.EvalSymlinks(filepath.Join("/dev/disk/by-label", strings.TrimPrefix(device, "LABEL=")))
+	case strings.HasPrefix(device, "/dev/"):
+		if resolved, err := filepath.EvalSymlinks(device); err == nil {
+			return resolved, nil
+		}
+	}
+	return device, nil
+}
+
+func sameDevice(mounted, declared string) bool {
+	if mounted == declared {
+		return true
+	}
+	resolved, err := resolveDevice(declared)
+	if err != nil {
+		return false
+	}
+	other, _ := resolveDevice(mounted)
+	return resolved == other
+}
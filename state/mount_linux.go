@@ -0,0 +1,68 @@
+package state
+
+import (
+	"strings"
+	"syscall"
+)
+
+var mountFlags = map[string]uintptr{
+	"rw":          0,
+	"ro":          syscall.MS_RDONLY,
+	"nosuid":      syscall.MS_NOSUID,
+	"nodev":       syscall.MS_NODEV,
+	"noexec":      syscall.MS_NOEXEC,
+	"sync":        syscall.MS_SYNCHRONOUS,
+	"dirsync":     syscall.MS_DIRSYNC,
+	"mand":        syscall.MS_MANDLOCK,
+	"noatime":     syscall.MS_NOATIME,
+	"nodiratime":  syscall.MS_NODIRATIME,
+	"relatime":    syscall.MS_RELATIME,
+	"strictatime": syscall.MS_STRICTATIME,
+	"bind":        syscall.MS_BIND,
+	"rbind":       syscall.MS_BIND | syscall.MS_REC,
+}
+
+/*
+Mount a filesystem with the mount(2) system call
+*/
+func mountFilesystem(device, path, fstype, options string, remount bool) error {
+	flags, data := parseMountOptions(options)
+	if remount {
+		flags |= syscall.MS_REMOUNT
+	}
+	return syscall.Mount(device, path, fstype, flags, data)
+}
+
+/*
+Unmount a filesystem with the umount(2) system call
+*/
+func unmountFilesystem(path string) error {
+	return syscall.Unmount(path, 0)
+}
+
+/*
+Split comma separated mount options into mount flags and filesystem specific data
+*/
+func parseMountOptions(options string) (uintptr, string) {
+	var flags uintptr
+	data := make([]string, 0)
+	for _, option := range strings.Split(options, ",") {
+		if flag, ok := mountFlags[option]; ok {
+			flags |= flag
+			continue
+		}
+		if option == "" || strings.HasPrefix(option, "x-") || strings.HasPrefix(option, "comment=") {
+			continue
+		}
+		ignore := false
+		for _, o := range mountDefaults {
+			if option == o {
+				ignore = true
+			}
+		}
+		if !ignore {
+			data = append(data, option)
+		}
+	}
+	return flags, strings.Join(data, ",")
+}
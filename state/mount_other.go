@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package state
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func mountFilesystem(device, path, fstype, options string, remount bool) error {
+	return fmt.Errorf("Mounting filesystems is not supported on %s", runtime.GOOS)
+}
+
+func unmountFilesystem(path string) error {
+	return fmt.Errorf("Unmounting filesystems is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,150 @@
+package state
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var mountInfoFile = []byte(`22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw,errors=remount-ro
+35 22 8:17 / /var/lib/docker rw,noatime shared:20 - xfs /dev/sdb1 rw,attr2,inode64,noquota
+36 22 0:31 / /mnt/with\040space rw,relatime - tmpfs tmpfs rw,size=1024k
+`)
+
+var fstabFile = `# <file system> <mount point> <type> <options> <dump> <pass>
+/dev/sda1	/	ext4	errors=remount-ro	0	1
+/dev/sdb1	/var/lib/docker	xfs	defaults	0	0
+`
+
+/*
+Point the mountinfo and fstab paths at a temporary directory, the returned function restores them
+*/
+func mountSetup(state, data string, t *testing.T) (State, string, func()) {
+	dir, err := ioutil.TempDir("", "otter-mount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldMountInfo, oldFstab := mountInfoPath, fstabPath
+	restore := func() {
+		mountInfoPath, fstabPath = oldMountInfo, oldFstab
+		os.RemoveAll(dir)
+	}
+	mountInfoPath = filepath.Join(dir, "mountinfo")
+	fstabPath = filepath.Join(dir, "fstab")
+	ioutil.WriteFile(mountInfoPath, mountInfoFile, 0644)
+	ioutil.WriteFile(fstabPath, []byte(fstabFile), 0644)
+	metadata := Metadata{Name: "/var/lib/docker", Type: "mount", State: state}
+	return stateSetup(metadata, []byte(data), t), dir, restore
+}
+
+func TestParseMountInfo(t *testing.T) {
+	mounts, err := parseMountInfo(mountInfoFile)
+	if err != nil {
+		fmt.Println("Failed to parse mountinfo: ", err)
+		t.FailNow()
+	}
+	if len(mounts) != 3 {
+		fmt.Println("Did not parse correct amount of mounts: ", len(mounts))
+		t.FailNow()
+	}
+	if mounts[1].Device != "/dev/sdb1" || mounts[1].FSType != "xfs" || len(mounts[1].Options) != 6 {
+		fmt.Println("Bad mount entry: ", mounts[1])
+		t.Fail()
+	}
+	if mounts[2].Path != "/mnt/with space" {
+		fmt.Println("Failed to unescape mount point: ", mounts[2].Path)
+		t.Fail()
+	}
+}
+
+func TestMountOptionsDrift(t *testing.T) {
+	state, _, restore := mountSetup("mounted", `{"device": "/dev/sdb1", "fstype": "xfs", "options": "defaults,noatime,nofail"}`, t)
+	defer restore()
+	mount := state.(*Mount)
+	info, _, _ := mount.mounted()
+	if missing := mount.missingOptions(info); len(missing) != 0 {
+		fmt.Println("Detected missing options on consistent mount: ", missing)
+		t.Fail()
+	}
+	mount.Options = "rw,exec,suid,dev,async,diratime,nomand,bind,noatime"
+	if missing := mount.missingOptions(info); len(missing) != 0 {
+		fmt.Println("Detected implied default options as missing: ", missing)
+		t.Fail()
+	}
+	mount.Options = "noatime,nodev"
+	if missing := mount.missingOptions(info); len(missing) != 1 || missing[0] != "nodev" {
+		fmt.Println("Failed to detect option drift: ", missing)
+		t.Fail()
+	}
+}
+
+func TestMountReadOnlyDrift(t *testing.T) {
+	state, _, restore := mountSetup("mounted", `{"device": "/dev/sdb1", "fstype": "xfs", "options": "defaults"}`, t)
+	defer restore()
+	ioutil.WriteFile(mountInfoPath, []byte(strings.Replace(string(mountInfoFile), "rw,noatime shared:20 - xfs /dev/sdb1 rw,", "ro,noatime shared:20 - xfs /dev/sdb1 ro,", 1)), 0644)
+	mount := state.(*Mount)
+	info, _, _ := mount.mounted()
+	if missing := mount.missingOptions(info); len(missing) != 1 || missing[0] != "rw" {
+		fmt.Println("Failed to detect a read-only remount: ", missing)
+		t.Fail()
+	}
+	mount.Options = "ro"
+	if missing := mount.missingOptions(info); len(missing) != 0 {
+		fmt.Println("Detected missing options on a read-only mount: ", missing)
+		t.Fail()
+	}
+}
+
+func TestMountBind(t *testing.T) {
+	state, _, restore := mountSetup("mounted", `{"device": "/srv/docker", "fstype": "none", "options": "bind"}`, t)
+	defer restore()
+	ioutil.WriteFile(fstabPath, []byte(strings.Replace(fstabFile, "/dev/sdb1\t/var/lib/docker\txfs\tdefaults", "/srv/docker\t/var/lib/docker\tnone\tbind", 1)), 0644)
+	result := state.State()
+	if !result.Consistent {
+		fmt.Println("Bind mount should be consistent: ", result.Message)
+		t.Fail()
+	}
+}
+
+func TestMountFstabDrift(t *testing.T) {
+	state, _, restore := mountSetup("mounted", `{"device": "/dev/sdb1", "fstype": "xfs", "options": "noatime", "pass": 2}`, t)
+	defer restore()
+	if state.State().Consistent {
+		fmt.Println("Failed to detect fstab drift")
+		t.Fail()
+	}
+	err := state.(*Mount).updateFstab(false)
+	if err != nil {
+		fmt.Println("Failed to update fstab: ", err)
+		t.FailNow()
+	}
+	data, _ := ioutil.ReadFile(fstabPath)
+	if !strings.Contains(string(data), "/dev/sdb1\t/var/lib/docker\txfs\tnoatime\t0\t2") || !strings.HasPrefix(string(data), "# <file system>") {
+		fmt.Println("Bad fstab update: ", string(data))
+		t.Fail()
+	}
+	if !state.State().Consistent {
+		fmt.Println("Mount not consistent after fstab update")
+		t.Fail()
+	}
+}
+
+func TestMountAbsentFstab(t *testing.T) {
+	state, _, restore := mountSetup("absent", `{}`, t)
+	defer restore()
+	state.(*Mount).Path = "/srv/data"
+	ioutil.WriteFile(fstabPath, []byte(fstabFile+"/dev/sdc1 /srv/data ext4 defaults 0 0\n"), 0644)
+	if state.State().Consistent {
+		fmt.Println("Failed to detect fstab entry for absent mount")
+		t.Fail()
+	}
+	result := state.Apply()
+	data, _ := ioutil.ReadFile(fstabPath)
+	if !result.Consistent || string(data) != fstabFile {
+		fmt.Println("Failed to remove fstab entry: ", result.Message, string(data))
+		t.Fail()
+	}
+}
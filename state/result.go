@@ -6,10 +6,12 @@ import (
 )
 
 type Result struct {
-	Host       string    // The host that produced this Result object
-	Consistent bool      // The state is consistent with the operating system
-	Metadata   *Metadata // The metadata of the state which returned this result
-	Message    string    // A message returned by the state
+	Host       string            // The host that produced this Result object
+	Consistent bool              // The state is consistent with the operating system
+	Metadata   *Metadata         // The metadata of the state which returned this result
+	Message    string            // A message returned by the state
+	Details    map[string]string // Additional key/value information returned by the state
+	Skipped    bool              // The state was not applied because its when expression is false on the host
 }
 
 /*
@@ -0,0 +1,410 @@
+/*
+An SSHKey represents one or more entries in a user's authorized_keys file.
+States -
+  present: The keys are present in the authorized_keys file
+  absent: The keys are not present in the authorized_keys file
+*/
+
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var sshKeyTypes = []string{
+	"ssh-rsa",
+	"ssh-dss",
+	"ssh-ed25519",
+	"ecdsa-sha2-nistp256",
+	"ecdsa-sha2-nistp384",
+	"ecdsa-sha2-nistp521",
+	"sk-ssh-ed25519@openssh.com",
+	"sk-ecdsa-sha2-nistp256@openssh.com",
+}
+
+type SSHKey struct {
+	User      string   `json:"user"`      // Owner of the authorized_keys file
+	Enc       string   `json:"enc"`       // Key type, "ssh-rsa", "ssh-ed25519", etc.
+	Key       string   `json:"key"`       // Base64 encoded public key
+	Comment   string   `json:"comment"`   // Comment appended to the key entry
+	Options   []string `json:"options"`   // Options prepended to the key entry
+	Keys      []string `json:"keys"`      // Additional complete authorized_keys entries
+	Path      string   `json:"path"`      // Location of authorized_keys, defaults to ~/.ssh/authorized_keys
+	Exclusive bool     `json:"exclusive"` // Remove all keys not declared by this state
+	Metadata  Metadata `json:"metadata"`
+}
+
+type authorizedKey struct {
+	Options []string
+	Enc     string
+	Key     string
+	Comment string
+}
+
+func (sshKey *SSHKey) Meta() Metadata {
+	return sshKey.Metadata
+}
+
+func (sshKey *SSHKey) State() *Result {
+	result := &Result{
+		Metadata:   &sshKey.Metadata,
+		Consistent: false,
+	}
+	path, err := sshKey.path()
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	lines, err := readAuthorizedKeys(path)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	declared, err := sshKey.declaredKeys()
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	existing := make([]authorizedKey, 0)
+	for _, line := range lines {
+		if entry, err := parseAuthorizedKey(line); err == nil {
+			existing = append(existing, entry)
+		}
+	}
+	current := indexKeys(existing)
+	switch sshKey.Metadata.State {
+	case "present":
+		for _, entry := range declared {
+			found, exists := current[entry.Key]
+			if !exists {
+				result.Message = fmt.Sprintf("Key %s is missing from %s", entry.short(), path)
+				return result
+			}
+			if found.String() != entry.String() {
+				result.Message = fmt.Sprintf("Key %s differs in %s", entry.short(), path)
+				return result
+			}
+		}
+		if sshKey.Exclusive {
+			wanted := indexKeys(declared)
+			for _, entry := range existing {
+				if _, ok := wanted[entry.Key]; !ok {
+					result.Message = fmt.Sprintf("Unmanaged key %s found in %s", entry.short(), path)
+					return result
+				}
+			}
+		}
+	case "absent":
+		for _, entry := range declared {
+			if _, exists := current[entry.Key]; exists {
+				result.Message = fmt.Sprintf("Key %s is present in %s", entry.short(), path)
+				return result
+			}
+		}
+	}
+	result.Consistent = true
+	return result
+}
+
+func (sshKey *SSHKey) Apply() *Result {
+	result := sshKey.State()
+	if result.Consistent == true {
+		return result
+	}
+	path, err := sshKey.path()
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	lines, err := readAuthorizedKeys(path)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	declared, err := sshKey.declaredKeys()
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	err = sshKey.writeLines(path, sshKey.updateLines(path, lines, declared))
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	switch sshKey.Metadata.State {
+	case "present":
+		result.Message = "Keys present"
+	case "absent":
+		result.Message = "Keys removed"
+	}
+	result.Consistent = true
+	return result
+}
+
+/*
+Create and validate a new SSHKey State
+*/
+func newSSHKey(metadata Metadata, data []byte) (*SSHKey, error) {
+	sshKey := &SSHKey{}
+	err := json.Unmarshal(data, &sshKey)
+	if err != nil {
+		return nil, err
+	}
+	sshKey.Metadata = metadata
+	switch metadata.State {
+	case "present":
+	case "absent":
+	default:
+		return nil, fmt.Errorf("Invalid sshkey state: %s", metadata.State)
+	}
+	if sshKey.User == "" {
+		sshKey.User = "root"
+	}
+	if sshKey.Enc == "" {
+		sshKey.Enc = "ssh-rsa"
+	}
+	if sshKey.Key == "" && len(sshKey.Keys) == 0 {
+		return nil, fmt.Errorf("No keys declared for sshkey state: %s", metadata.Name)
+	}
+	_, err = sshKey.declaredKeys()
+	if err != nil {
+		return nil, err
+	}
+	return sshKey, nil
+}
+
+/*
+Return the location of the authorized_keys file, the user's home directory is looked up on the host the state is
+applied to as the user may not exist where the state was loaded
+*/
+func (sshKey *SSHKey) path() (string, error) {
+	if sshKey.Path != "" {
+		return sshKey.Path, nil
+	}
+	u, err := user.Lookup(sshKey.User)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(u.HomeDir, ".ssh", "authorized_keys"), nil
+}
+
+/*
+Return every key declared by this state in the order it was declared
+*/
+func (sshKey *SSHKey) declaredKeys() ([]authorizedKey, error) {
+	declared := make([]authorizedKey, 0)
+	if sshKey.Key != "" {
+		declared = append(declared, authorizedKey{
+			Options: sshKey.Options,
+			Enc:     sshKey.Enc,
+			Key:     sshKey.Key,
+			Comment: sshKey.Comment,
+		})
+	}
+	for _, line := range sshKey.Keys {
+		entry, err := parseAuthorizedKey(line)
+		if err != nil {
+			return nil, err
+		}
+		declared = append(declared, entry)
+	}
+	return declared, nil
+}
+
+/*
+Rewrite the lines of an authorized_keys file, unrelated lines and comments are left in place
+*/
+func (sshKey *SSHKey) updateLines(path string, lines []string, declared []authorizedKey) []string {
+	updated := make([]string, 0)
+	wanted := indexKeys(declared)
+	written := make(map[string]bool)
+	for _, line := range lines {
+		entry, err := parseAuthorizedKey(line)
+		if err != nil {
+			updated = append(updated, line) // Blank lines, comments and anything we don't understand
+			continue
+		}
+		_, isDeclared := wanted[entry.Key]
+		switch {
+		case sshKey.Metadata.State == "absent" && isDeclared:
+		case sshKey.Metadata.State == "present" && isDeclared:
+			if !written[entry.Key] {
+				updated = append(updated, wanted[entry.Key].String())
+				written[entry.Key] = true
+			}
+		case sshKey.Metadata.State == "present" && sshKey.Exclusive:
+			log.Printf("Removing unmanaged key %s from %s", entry.short(), path)
+		default:
+			updated = append(updated, line)
+		}
+	}
+	if sshKey.Metadata.State == "present" {
+		for _, entry := range declared {
+			if !written[entry.Key] {
+				updated = append(updated, entry.String())
+				written[entry.Key] = true
+			}
+		}
+	}
+	return updated
+}
+
+/*
+Read the authorized_keys file, a missing file has no lines
+*/
+func readAuthorizedKeys(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(lines) == 1 && lines[0] == "" {
+		return []string{}, nil
+	}
+	return lines, nil
+}
+
+/*
+Write the authorized_keys file, creating the parent directory and setting ownership and permissions
+*/
+func (sshKey *SSHKey) writeLines(path string, lines []string) error {
+	u, err := user.Lookup(sshKey.User)
+	if err != nil {
+		return err
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		err = os.MkdirAll(dir, 0700)
+		if err != nil {
+			return err
+		}
+		err = os.Chown(dir, uid, gid)
+		if err != nil {
+			return err
+		}
+	}
+	data := ""
+	if len(lines) > 0 {
+		data = strings.Join(lines, "\n") + "\n"
+	}
+	log.Printf("Writing %d lines to %s", len(lines), path)
+	err = ioutil.WriteFile(path, []byte(data), 0600)
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(path, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Chown(path, uid, gid)
+}
+
+/*
+Parse a single authorized_keys entry in the form [options] keytype key [comment]
+*/
+func parseAuthorizedKey(line string) (authorizedKey, error) {
+	entry := authorizedKey{}
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return entry, fmt.Errorf("Not an authorized key entry: %s", line)
+	}
+	if !isSSHKeyType(strings.Fields(line)[0]) {
+		options, rest := splitKeyOptions(line)
+		entry.Options = options
+		line = rest
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 2 || !isSSHKeyType(fields[0]) {
+		return entry, fmt.Errorf("Unable to parse authorized key entry: %s", line)
+	}
+	entry.Enc = fields[0]
+	entry.Key = fields[1]
+	entry.Comment = strings.Join(fields[2:], " ")
+	return entry, nil
+}
+
+/*
+Split the leading comma separated options from an authorized_keys entry, options may contain quoted strings
+*/
+func splitKeyOptions(line string) ([]string, string) {
+	options := make([]string, 0)
+	quoted := false
+	start := 0
+	for i, c := range line {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			options = append(options, line[start:i])
+			start = i + 1
+		case (c == ' ' || c == '\t') && !quoted:
+			return append(options, line[start:i]), strings.TrimSpace(line[i:])
+		}
+	}
+	return append(options, line[start:]), ""
+}
+
+func indexKeys(entries []authorizedKey) map[string]authorizedKey {
+	index := make(map[string]authorizedKey)
+	for _, entry := range entries {
+		index[entry.Key] = entry
+	}
+	return index
+}
+
+func isSSHKeyType(s string) bool {
+	for _, keyType := range sshKeyTypes {
+		if s == keyType {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Render the entry as a line in an authorized_keys file
+*/
+func (entry authorizedKey) String() string {
+	fields := make([]string, 0)
+	if len(entry.Options) > 0 {
+		fields = append(fields, strings.Join(entry.Options, ","))
+	}
+	fields = append(fields, entry.Enc, entry.Key)
+	if entry.Comment != "" {
+		fields = append(fields, entry.Comment)
+	}
+	return strings.Join(fields, " ")
+}
+
+/*
+Return a short representation of the key suitable for messages
+*/
+func (entry authorizedKey) short() string {
+	key := entry.Key
+	if len(key) > 12 {
+		key = "..." + key[len(key)-12:]
+	}
+	if entry.Comment != "" {
+		return fmt.Sprintf("%s %s (%s)", entry.Enc, key, entry.Comment)
+	}
+	return fmt.Sprintf("%s %s", entry.Enc, key)
+}
@@ -0,0 +1,114 @@
+package state
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var authorizedKeys = `# managed by hand
+ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQCold old@example.com
+no-port-forwarding,command="echo hello, world" ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIforced forced@example.com
+`
+
+func sshKeySetup(state, data string, t *testing.T) (State, string) {
+	dir, err := ioutil.TempDir("", "otter-sshkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, ".ssh", "authorized_keys")
+	metadata := Metadata{Name: "operator", Type: "sshkey", State: state}
+	data = fmt.Sprintf(`{"user": "%s", "path": "%s", %s}`, current.Username, path, data)
+	return stateSetup(metadata, []byte(data), t), path
+}
+
+func TestParseAuthorizedKey(t *testing.T) {
+	lines := strings.Split(authorizedKeys, "\n")
+	if _, err := parseAuthorizedKey(lines[0]); err == nil {
+		fmt.Println("Parsed comment as a key")
+		t.Fail()
+	}
+	entry, err := parseAuthorizedKey(lines[2])
+	if err != nil {
+		fmt.Println("Failed to parse key with options: ", err)
+		t.FailNow()
+	}
+	if len(entry.Options) != 2 || entry.Options[1] != `command="echo hello, world"` || entry.Enc != "ssh-ed25519" || entry.Comment != "forced@example.com" {
+		fmt.Println("Bad key entry: ", entry)
+		t.Fail()
+	}
+	if entry.String() != lines[2] {
+		fmt.Println("Key entry did not round trip: ", entry.String())
+		t.Fail()
+	}
+}
+
+func TestSSHKeyPresent(t *testing.T) {
+	state, path := sshKeySetup("present", `"key": "AAAAC3NzaC1lZDI1NTE5AAAAInew", "enc": "ssh-ed25519", "comment": "new@example.com"`, t)
+	defer os.RemoveAll(filepath.Dir(filepath.Dir(path)))
+	result := state.Apply()
+	if !result.Consistent {
+		fmt.Println("Failed to add key: ", result.Message)
+		t.FailNow()
+	}
+	info, err := os.Stat(filepath.Dir(path))
+	if err != nil || info.Mode().Perm() != 0700 {
+		fmt.Println("Bad .ssh directory permissions: ", info.Mode())
+		t.Fail()
+	}
+	if !state.State().Consistent {
+		fmt.Println("Key not consistent after apply")
+		t.Fail()
+	}
+}
+
+func TestSSHKeyExclusive(t *testing.T) {
+	state, path := sshKeySetup("present", `"key": "AAAAB3NzaC1yc2EAAAADAQABAAABAQCold", "comment": "old@example.com", "exclusive": true`, t)
+	defer os.RemoveAll(filepath.Dir(filepath.Dir(path)))
+	os.MkdirAll(filepath.Dir(path), 0700)
+	ioutil.WriteFile(path, []byte(authorizedKeys), 0600)
+	if state.State().Consistent {
+		fmt.Println("Failed to detect unmanaged key")
+		t.Fail()
+	}
+	state.Apply()
+	data, _ := ioutil.ReadFile(path)
+	if strings.Contains(string(data), "forced@example.com") || !strings.Contains(string(data), "# managed by hand") {
+		fmt.Println("Bad exclusive key update: ", string(data))
+		t.Fail()
+	}
+}
+
+func TestSSHKeyAbsent(t *testing.T) {
+	state, path := sshKeySetup("absent", `"keys": ["ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQCold"]`, t)
+	defer os.RemoveAll(filepath.Dir(filepath.Dir(path)))
+	os.MkdirAll(filepath.Dir(path), 0700)
+	ioutil.WriteFile(path, []byte(authorizedKeys), 0600)
+	result := state.Apply()
+	data, _ := ioutil.ReadFile(path)
+	if !result.Consistent || strings.Contains(string(data), "old@example.com") || !strings.Contains(string(data), "forced@example.com") {
+		fmt.Println("Failed to remove key: ", string(data))
+		t.Fail()
+	}
+}
+
+func TestSSHKeyUnknownUser(t *testing.T) {
+	metadata := Metadata{Name: "deploy", Type: "sshkey", State: "present"}
+	state := stateSetup(metadata, []byte(`{"user": "otter-no-such-user", "key": "AAAAC3NzaC1lZDI1NTE5AAAAInew"}`), t)
+	if state.(*SSHKey).Path != "" {
+		fmt.Println("Resolved authorized_keys path while loading: ", state.(*SSHKey).Path)
+		t.Fail()
+	}
+	if result := state.State(); result.Consistent || !strings.Contains(result.Message, "otter-no-such-user") {
+		fmt.Println("Failed to report unknown user on the host: ", result.Message)
+		t.Fail()
+	}
+}
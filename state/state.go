@@ -1,10 +1,9 @@
 package state
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
-	"github.com/ghodss/yaml"
-	"io/ioutil"
 	"os"
 	"os/user"
 	"strings"
@@ -17,20 +16,22 @@ type State interface {
 }
 
 type StateMap struct {
-	States []State
+	States  []State
+	Targets []*Target // Targets restricting states to a subset of hosts
+	Vars    Vars      // Variables used to evaluate the when expressions of states
 }
 
 /*
 Add a new state to the StateMap
 */
 func (sm *StateMap) Add(entry State) error {
-	if sm.Exists(entry.Meta(), false) {
-		return fmt.Errorf("Detected duplicate state entry: %s", entry.Meta())
-	}
 	md := entry.Meta()
+	if sm.Exists(md, false) {
+		return md.Errorf("Detected duplicate state entry: %s %s.%s", md.Name, md.Type, md.State)
+	}
 	for _, requirement := range md.Requirements {
-		if !sm.Exists(Metadata{Name: requirement}, true) {
-			return fmt.Errorf("Unable to find requirement: %s", requirement)
+		if !sm.Satisfies(requirement) {
+			return md.Errorf("Unable to find requirement: %s", requirement)
 		}
 	}
 	sm.States = append(sm.States, entry)
@@ -43,15 +44,17 @@ Add multiple states to the StateMap
 func (sm *StateMap) AddMany(entries []State, attempts, max int) error {
 	attempts++
 	retry := make([]State, 0)
+	errs := make([]string, 0)
 	for _, entry := range entries {
 		err := sm.Add(entry)
 		if err != nil {
 			retry = append(retry, entry)
+			errs = append(errs, err.Error())
 		}
 	}
 	if len(retry) >= 1 {
 		if attempts > max {
-			return fmt.Errorf("Unable to load %d states: %s", len(retry), retry)
+			return fmt.Errorf("Unable to load %d states: %s", len(retry), strings.Join(errs, ", "))
 		}
 		return sm.AddMany(retry, attempts, max)
 	}
@@ -76,6 +79,19 @@ func (sm *StateMap) Exists(other Metadata, nameOnly bool) bool {
 	return false
 }
 
+/*
+Check to see if a requirement, either a state name or ID, refers to a loaded state
+*/
+func (sm *StateMap) Satisfies(requirement string) bool {
+	for _, state := range sm.States {
+		metadata := state.Meta()
+		if metadata.Satisfies(requirement) {
+			return true
+		}
+	}
+	return false
+}
+
 /*
 Apply all states loaded in the StateMap
 */
@@ -111,7 +127,9 @@ func (sm *StateMap) ToJson() ([]byte, error) {
 
 func NewStateMap() *StateMap {
 	sm := &StateMap{
-		States: make([]State, 0),
+		States:  make([]State, 0),
+		Targets: make([]*Target, 0),
+		Vars:    make(Vars),
 	}
 	return sm
 }
@@ -124,13 +142,19 @@ func StateMapFromProcessedJson(data []byte) (*StateMap, error) {
 	raw := make([]json.RawMessage, 0)
 	err := json.Unmarshal(data, &raw)
 	if err != nil {
-		return nil, err
+		return nil, &DecodeError{Err: err}
 	}
 	states := make([]State, 0)
 	for _, value := range raw {
 		metadata, err := MetadataFromJSON(value)
 		if err != nil {
-			return nil, err
+			return nil, &DecodeError{Err: err}
+		}
+		skipped := skippedState{}
+		json.Unmarshal(value, &skipped)
+		if skipped.Skipped {
+			states = append(states, &skipped) // Skipped states are not created as they are never applied
+			continue
 		}
 		state, err := StateFactory(metadata, []byte(value))
 		if err != nil {
@@ -147,17 +171,29 @@ Load a StateMap from a JSON byte array
 */
 func StateMapFromJson(data []byte) (*StateMap, error) {
 	sm := NewStateMap()
-	raw := make(map[string]map[string]json.RawMessage)
-	err := json.Unmarshal(data, &raw)
+	declarations, err := rawObject(data)
 	if err != nil {
 		return nil, err
 	}
 	states := make([]State, 0)
-	for name, value := range raw {
-		for keyword, data := range value {
-			split := strings.Split(keyword, ".")
-			metadata := Metadata{Name: name, Type: split[0], State: split[1]}
-			state, err := StateFactory(metadata, data)
+	for _, declaration := range declarations {
+		name := declaration.key
+		keywords, err := jsonKeywords(declaration.value)
+		if err != nil {
+			return sm, fmt.Errorf("Invalid state %s: %s", name, err)
+		}
+		names := make([]string, 0)
+		for _, keyword := range keywords {
+			names = append(names, keyword.key)
+		}
+		ids := stateIDs(name, names)
+		for i, keyword := range keywords {
+			split := strings.Split(keyword.key, ".")
+			if len(split) != 2 {
+				return sm, fmt.Errorf("Invalid keyword %s, expected <type>.<state>", keyword.key)
+			}
+			metadata := Metadata{ID: ids[i], Name: name, Type: split[0], State: split[1]}
+			state, err := StateFactory(metadata, keyword.value)
 			if err != nil {
 				return sm, err
 			}
@@ -168,22 +204,89 @@ func StateMapFromJson(data []byte) (*StateMap, error) {
 	return sm, err
 }
 
+type rawMember struct {
+	key   string
+	value json.RawMessage
+}
+
 /*
-Load a StateMap from a YAML byte array
+Decode a JSON object keeping its members in the order they appear
 */
-func StateMapFromYaml(data []byte) (*StateMap, error) {
-	j, err := yaml.YAMLToJSON(data)
+func rawObject(data []byte) ([]rawMember, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	token, err := decoder.Token()
 	if err != nil {
 		return nil, err
 	}
-	return StateMapFromJson(j)
+	if delim, ok := token.(json.Delim); !ok || delim != '{' {
+		return nil, fmt.Errorf("Expected a JSON object")
+	}
+	members := make([]rawMember, 0)
+	for decoder.More() {
+		token, err = decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		member := rawMember{key: token.(string)}
+		err = decoder.Decode(&member.value)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, nil
+}
 
+/*
+Return the keywords declared under a name, either an object of keywords or a list of objects with a single keyword
+*/
+func jsonKeywords(value json.RawMessage) ([]rawMember, error) {
+	if !strings.HasPrefix(strings.TrimSpace(string(value)), "[") {
+		return rawObject(value)
+	}
+	list := make([]json.RawMessage, 0)
+	err := json.Unmarshal(value, &list)
+	if err != nil {
+		return nil, err
+	}
+	keywords := make([]rawMember, 0)
+	for _, item := range list {
+		members, err := rawObject(item)
+		if err != nil {
+			return nil, err
+		}
+		if len(members) != 1 {
+			return nil, fmt.Errorf("Expected a single keyword in each list item")
+		}
+		keywords = append(keywords, members[0])
+	}
+	return keywords, nil
 }
 
 /*
-Load a YAML file from a given path, if the file doesn't exist default to ~/.otter
+Load a StateMap from a YAML byte array, included files are relative to the working directory
+*/
+func StateMapFromYaml(data []byte) (*StateMap, error) {
+	loader := newYamlLoader()
+	err := loader.load(data, "")
+	if err != nil {
+		return nil, err
+	}
+	return loader.stateMap(nil)
+}
+
+/*
+Load a YAML file from a given path, if the file doesn't exist default to ~/.otter.
+Files referenced by include directives are loaded relative to the including file.
 */
 func StateMapFromYamlPath(path string) (*StateMap, error) {
+	return StateMapFromYamlPathWithVars(path, nil)
+}
+
+/*
+Load a YAML file from a given path, vars take precedence over variables declared in the loaded files
+*/
+func StateMapFromYamlPathWithVars(path string, vars Vars) (*StateMap, error) {
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		user, err := user.Current()
 		if err != nil {
@@ -191,9 +294,10 @@ func StateMapFromYamlPath(path string) (*StateMap, error) {
 		}
 		path = user.HomeDir + "/.otter"
 	}
-	data, err := ioutil.ReadFile(path)
+	loader := newYamlLoader()
+	err := loader.loadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	return StateMapFromYaml(data)
+	return loader.stateMap(vars)
 }
@@ -3,6 +3,7 @@ package state
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -123,3 +124,85 @@ func TestCircularRequirement(t *testing.T) {
 		t.Fail()
 	}
 }
+
+var listed = []byte(`
+docker:
+  - file.rendered:
+      source: /srv/docker.default
+      path: /etc/default/docker
+  - file.rendered:
+      source: /srv/daemon.json
+      path: /etc/docker/daemon.json
+  - service.running:
+      require:
+        - docker:file.rendered:2
+kubelet:
+  package.installed:
+    require:
+      - docker
+`)
+
+func TestStateList(t *testing.T) {
+	stateMap := loadStateMapFromYaml(listed, t)
+	ids := make([]string, 0)
+	for _, state := range stateMap.States {
+		ids = append(ids, state.Meta().ID)
+	}
+	if strings.Join(ids, ",") != "docker:file.rendered,docker:file.rendered:2,docker:service.running,kubelet:package.installed" {
+		fmt.Println("Bad state IDs: ", ids)
+		t.Fail()
+	}
+	results := NewResultMap()
+	for _, state := range stateMap.States[:2] {
+		metadata := state.Meta()
+		results.Add(&Result{Host: "localhost", Metadata: &metadata})
+	}
+	if len(results.Results["localhost"]) != 2 {
+		fmt.Println("Results of states with the same type were merged: ", results.Results)
+		t.Fail()
+	}
+	_, err := StateMapFromYaml([]byte("docker:\n  - file.rendered: {}\n    service.running: {}\n"))
+	if err == nil {
+		fmt.Println("Failed to detect multiple keywords in a list item")
+		t.Fail()
+	}
+	_, err = StateMapFromYaml([]byte("docker:\n  - file.rendered: {}\nkubelet:\n  package.installed:\n    require:\n      - docker:file.rendered:1\n"))
+	if err == nil {
+		fmt.Println("Failed to detect missing requirement ID")
+		t.Fail()
+	}
+}
+
+func TestStateOrder(t *testing.T) {
+	stateMap, err := StateMapFromJson([]byte(`{"zookeeper": {"package.installed": {}}, "mesos": {"package.installed": {}, "service.running": {}}, "docker": {"package.installed": {}}}`))
+	if err != nil {
+		fmt.Println("Failed to load JSON: ", err)
+		t.FailNow()
+	}
+	ids := make([]string, 0)
+	for _, state := range stateMap.States {
+		ids = append(ids, state.Meta().ID)
+	}
+	if strings.Join(ids, ",") != "zookeeper:package.installed,mesos:package.installed,mesos:service.running,docker:package.installed" {
+		fmt.Println("States were not loaded in declared order: ", ids)
+		t.Fail()
+	}
+}
+
+func TestStatePosition(t *testing.T) {
+	stateMap := loadStateMapFromYaml(simple, t)
+	locations := make([]string, 0)
+	for _, state := range stateMap.States {
+		metadata := state.Meta()
+		locations = append(locations, metadata.Location())
+	}
+	if strings.Join(locations, ",") != "line 3,line 8,line 12,line 14" {
+		fmt.Println("Bad state positions: ", locations)
+		t.Fail()
+	}
+	_, err := StateMapFromYaml(missing)
+	if err == nil || !strings.Contains(err.Error(), "line 6: Unable to find requirement: zookeeper") {
+		fmt.Println("Missing requirement error has no position: ", err)
+		t.Fail()
+	}
+}
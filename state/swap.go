@@ -0,0 +1,245 @@
+/*
+A Swap represents swap space on an operating system and its entries in /etc/fstab.
+When no device is specified every swap device is managed.
+States -
+  disabled: Swap is not active and its fstab entries are commented out
+  enabled: Swap is active and its fstab entries are restored
+*/
+
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+var procSwapsPath = "/proc/swaps"
+
+// Prefix added to fstab lines commented out by Otter so they can be restored later
+const swapDisabledMarker = "#otter-disabled# "
+
+type Swap struct {
+	Device   string   `json:"device"` // Swap device or file, all swap is managed when empty
+	Metadata Metadata `json:"metadata"`
+}
+
+func (swap *Swap) Meta() Metadata {
+	return swap.Metadata
+}
+
+func (swap *Swap) State() *Result {
+	result := &Result{
+		Metadata:   &swap.Metadata,
+		Consistent: false,
+	}
+	active, err := swap.activeDevices()
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	lines, err := readFstab()
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	switch swap.Metadata.State {
+	case "disabled":
+		if len(active) > 0 {
+			result.Message = fmt.Sprintf("Swap is active on %s", strings.Join(active, ", "))
+			return result
+		}
+		if entries := swap.fstabEntries(lines, false); len(entries) > 0 {
+			result.Message = fmt.Sprintf("Swap is enabled in fstab for %s", strings.Join(entries, ", "))
+			return result
+		}
+	case "enabled":
+		if entries := swap.fstabEntries(lines, true); len(entries) > 0 {
+			result.Message = fmt.Sprintf("Swap is disabled in fstab for %s", strings.Join(entries, ", "))
+			return result
+		}
+		devices := swap.fstabEntries(lines, false)
+		if swap.Device != "" && len(devices) == 0 {
+			result.Message = fmt.Sprintf("Swap device %s is not in fstab", swap.Device)
+			return result
+		}
+		if swap.Device != "" && len(active) == 0 {
+			result.Message = fmt.Sprintf("Swap is not active on %s", swap.Device)
+			return result
+		}
+		for _, device := range devices {
+			if !containsDevice(active, device) {
+				result.Message = fmt.Sprintf("Swap is not active on %s", device)
+				return result
+			}
+		}
+	}
+	result.Consistent = true
+	return result
+}
+
+func (swap *Swap) Apply() *Result {
+	result := swap.State()
+	if result.Consistent == true {
+		return result
+	}
+	lines, err := readFstab()
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	switch swap.Metadata.State {
+	case "disabled":
+		if swap.Device != "" {
+			err = runCommand("swapoff", swap.Device)
+		} else {
+			err = runCommand("swapoff", "-a")
+		}
+		if err == nil {
+			err = writeFstab(swap.disableFstab(lines))
+		}
+		result.Message = "Swap disabled"
+	case "enabled":
+		err = writeFstab(swap.enableFstab(lines))
+		if err == nil && swap.Device != "" {
+			err = runCommand("swapon", swap.Device)
+		} else if err == nil {
+			err = runCommand("swapon", "-a")
+		}
+		result.Message = "Swap enabled"
+	}
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	result.Consistent = true
+	return result
+}
+
+/*
+Create and validate a new Swap State
+*/
+func newSwap(metadata Metadata, data []byte) (*Swap, error) {
+	swap := &Swap{}
+	err := json.Unmarshal(data, &swap)
+	if err != nil {
+		return nil, err
+	}
+	swap.Metadata = metadata
+	switch metadata.State {
+	case "disabled":
+	case "enabled":
+	default:
+		return nil, fmt.Errorf("Invalid swap state: %s", metadata.State)
+	}
+	return swap, nil
+}
+
+/*
+List the swap devices which are currently active and managed by this state
+*/
+func (swap *Swap) activeDevices() ([]string, error) {
+	data, err := ioutil.ReadFile(procSwapsPath)
+	if err != nil {
+		return nil, err
+	}
+	active := make([]string, 0)
+	for _, device := range parseProcSwaps(data) {
+		if swap.manages(device) {
+			active = append(active, device)
+		}
+	}
+	return active, nil
+}
+
+/*
+List the managed swap devices in fstab, either enabled entries or entries commented out by Otter
+*/
+func (swap *Swap) fstabEntries(lines []string, disabled bool) []string {
+	devices := make([]string, 0)
+	for _, line := range lines {
+		if disabled {
+			if !strings.HasPrefix(line, swapDisabledMarker) {
+				continue
+			}
+			line = strings.TrimPrefix(line, swapDisabledMarker)
+		}
+		entry, ok := parseFstabLine(line)
+		if ok && entry.FSType == "swap" && swap.manages(entry.Device) {
+			devices = append(devices, entry.Device)
+		}
+	}
+	return devices
+}
+
+/*
+Comment out every managed swap entry in fstab
+*/
+func (swap *Swap) disableFstab(lines []string) []string {
+	updated := make([]string, 0)
+	for _, line := range lines {
+		entry, ok := parseFstabLine(line)
+		if ok && entry.FSType == "swap" && swap.manages(entry.Device) {
+			line = swapDisabledMarker + line
+		}
+		updated = append(updated, line)
+	}
+	return updated
+}
+
+/*
+Restore every managed swap entry commented out by Otter, adding an entry for the declared device if needed
+*/
+func (swap *Swap) enableFstab(lines []string) []string {
+	updated := make([]string, 0)
+	found := false
+	for _, line := range lines {
+		if strings.HasPrefix(line, swapDisabledMarker) {
+			entry, ok := parseFstabLine(strings.TrimPrefix(line, swapDisabledMarker))
+			if ok && entry.FSType == "swap" && swap.manages(entry.Device) {
+				line = strings.TrimPrefix(line, swapDisabledMarker)
+			}
+		}
+		if entry, ok := parseFstabLine(line); ok && entry.FSType == "swap" && swap.manages(entry.Device) {
+			found = true
+		}
+		updated = append(updated, line)
+	}
+	if swap.Device != "" && !found {
+		entry := fstabEntry{Device: swap.Device, Path: "none", FSType: "swap", Options: "sw", Dump: "0", Pass: "0"}
+		updated = append(updated, entry.String())
+	}
+	return updated
+}
+
+/*
+Check if a swap device is managed by this state
+*/
+func (swap *Swap) manages(device string) bool {
+	return swap.Device == "" || sameDevice(device, swap.Device)
+}
+
+/*
+Parse the device names from /proc/swaps
+*/
+func parseProcSwaps(data []byte) []string {
+	devices := make([]string, 0)
+	for i, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if i == 0 || len(fields) == 0 { // Skip the header
+			continue
+		}
+		devices = append(devices, unescapeMountPath(fields[0]))
+	}
+	return devices
+}
+
+func containsDevice(devices []string, device string) bool {
+	for _, d := range devices {
+		if sameDevice(d, device) {
+			return true
+		}
+	}
+	return false
+}
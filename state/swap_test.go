@@ -0,0 +1,102 @@
+package state
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var procSwaps = []byte(`Filename				Type		Size	Used	Priority
+/dev/sda2                               partition	2097148	0	-2
+/swap\040file                           file		1048572	0	-3
+`)
+
+var swapFstab = []string{
+	"/dev/sda1	/	ext4	defaults	0	1",
+	"/dev/sda2	none	swap	sw	0	0",
+	"/swap\\040file	none	swap	sw	0	0",
+}
+
+/*
+Point the proc swaps and fstab paths at a temporary directory, the returned function restores them
+*/
+func swapSetup(state, data string, swaps []byte, t *testing.T) (State, string, func()) {
+	dir, err := ioutil.TempDir("", "otter-swap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldProcSwaps, oldFstab := procSwapsPath, fstabPath
+	restore := func() {
+		procSwapsPath, fstabPath = oldProcSwaps, oldFstab
+		os.RemoveAll(dir)
+	}
+	procSwapsPath = filepath.Join(dir, "swaps")
+	fstabPath = filepath.Join(dir, "fstab")
+	ioutil.WriteFile(procSwapsPath, swaps, 0644)
+	ioutil.WriteFile(fstabPath, []byte(strings.Join(swapFstab, "\n")+"\n"), 0644)
+	metadata := Metadata{Name: "swap", Type: "swap", State: state}
+	return stateSetup(metadata, []byte(data), t), dir, restore
+}
+
+func TestParseProcSwaps(t *testing.T) {
+	devices := parseProcSwaps(procSwaps)
+	if len(devices) != 2 || devices[0] != "/dev/sda2" || devices[1] != "/swap file" {
+		fmt.Println("Bad swap device list: ", devices)
+		t.Fail()
+	}
+}
+
+func TestSwapDisabledFstab(t *testing.T) {
+	state, _, restore := swapSetup("disabled", `{}`, procSwaps, t)
+	defer restore()
+	swap := state.(*Swap)
+	disabled := swap.disableFstab(swapFstab)
+	if disabled[0] != swapFstab[0] || !strings.HasPrefix(disabled[1], swapDisabledMarker) || !strings.HasPrefix(disabled[2], swapDisabledMarker) {
+		fmt.Println("Bad disabled fstab: ", disabled)
+		t.Fail()
+	}
+	if len(swap.fstabEntries(disabled, false)) != 0 || len(swap.fstabEntries(disabled, true)) != 2 {
+		fmt.Println("Swap entries still enabled in fstab: ", disabled)
+		t.Fail()
+	}
+	swap.Metadata.State = "enabled"
+	enabled := swap.enableFstab(disabled)
+	if strings.Join(enabled, "\n") != strings.Join(swapFstab, "\n") {
+		fmt.Println("Failed to restore fstab: ", enabled)
+		t.Fail()
+	}
+}
+
+func TestSwapDisabledState(t *testing.T) {
+	state, _, restore := swapSetup("disabled", `{}`, procSwaps, t)
+	defer restore()
+	if state.State().Consistent {
+		fmt.Println("Failed to detect active swap")
+		t.Fail()
+	}
+	empty := []byte("Filename				Type		Size	Used	Priority\n")
+	ioutil.WriteFile(procSwapsPath, empty, 0644)
+	result := state.State()
+	if result.Consistent || !strings.Contains(result.Message, "fstab") {
+		fmt.Println("Failed to detect swap enabled in fstab: ", result.Message)
+		t.Fail()
+	}
+}
+
+func TestSwapEnabledDevice(t *testing.T) {
+	state, _, restore := swapSetup("enabled", `{"device": "/dev/sdb2"}`, procSwaps, t)
+	defer restore()
+	swap := state.(*Swap)
+	if state.State().Consistent {
+		fmt.Println("Failed to detect missing swap device")
+		t.Fail()
+	}
+	enabled := swap.enableFstab(swapFstab)
+	if len(enabled) != 4 || enabled[3] != "/dev/sdb2\tnone\tswap\tsw\t0\t0" {
+		fmt.Println("Failed to add swap device to fstab: ", enabled)
+		t.Fail()
+	}
+}
@@ -0,0 +1,230 @@
+/*
+A Sysctl represents a kernel parameter, both at runtime and persisted to /etc/sysctl.d.
+States -
+  present: The kernel parameter is set at runtime and persisted
+*/
+
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var procSysPath = "/proc/sys"
+
+type Sysctl struct {
+	Key      string   `json:"key"`   // Kernel parameter, defaults to the state name
+	Value    string   `json:"value"` // Desired value of the parameter
+	File     string   `json:"file"`  // File the parameter is persisted to
+	Metadata Metadata `json:"metadata"`
+}
+
+func (sysctl *Sysctl) Meta() Metadata {
+	return sysctl.Metadata
+}
+
+func (sysctl *Sysctl) State() *Result {
+	result := &Result{
+		Metadata:   &sysctl.Metadata,
+		Consistent: false,
+	}
+	runtime, err := sysctl.runtimeValue()
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	persisted, err := sysctl.persistedValue()
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	result.Details = map[string]string{
+		"runtime":   runtime,
+		"persisted": persisted,
+	}
+	drift := make([]string, 0)
+	if runtime != sysctl.Value {
+		drift = append(drift, fmt.Sprintf("runtime value is %q, expected %q", runtime, sysctl.Value))
+	}
+	if persisted != sysctl.Value {
+		drift = append(drift, fmt.Sprintf("persisted value in %s is %q, expected %q", sysctl.File, persisted, sysctl.Value))
+	}
+	if len(drift) > 0 {
+		result.Message = fmt.Sprintf("%s: %s", sysctl.Key, strings.Join(drift, ", "))
+		return result
+	}
+	result.Consistent = true
+	return result
+}
+
+func (sysctl *Sysctl) Apply() *Result {
+	result := sysctl.State()
+	if result.Consistent == true {
+		return result
+	}
+	if result.Details["runtime"] != sysctl.Value {
+		err := sysctl.setRuntimeValue()
+		if err != nil {
+			result.Message = err.Error()
+			return result
+		}
+	}
+	if result.Details["persisted"] != sysctl.Value {
+		err := sysctl.persistValue()
+		if err != nil {
+			result.Message = err.Error()
+			return result
+		}
+	}
+	result.Details["runtime"] = sysctl.Value
+	result.Details["persisted"] = sysctl.Value
+	result.Message = fmt.Sprintf("Kernel parameter %s set to %s", sysctl.Key, sysctl.Value)
+	result.Consistent = true
+	return result
+}
+
+/*
+Create and validate a new Sysctl State
+*/
+func newSysctl(metadata Metadata, data []byte) (*Sysctl, error) {
+	sysctl := &Sysctl{}
+	err := json.Unmarshal(data, &sysctl)
+	if err != nil {
+		return nil, err
+	}
+	sysctl.Metadata = metadata
+	switch metadata.State {
+	case "present":
+	default:
+		return nil, fmt.Errorf("Invalid sysctl state: %s", metadata.State)
+	}
+	if sysctl.Key == "" {
+		sysctl.Key = metadata.Name
+	}
+	if sysctl.File == "" {
+		sysctl.File = "/etc/sysctl.d/99-otter.conf"
+	}
+	if strings.Contains(sysctl.Key, "..") || strings.ContainsAny(sysctl.Key, " =") {
+		return nil, fmt.Errorf("Invalid kernel parameter: %s", sysctl.Key)
+	}
+	sysctl.Value = normalizeSysctlValue(sysctl.Value)
+	if sysctl.Value == "" {
+		return nil, fmt.Errorf("No value specified for kernel parameter: %s", sysctl.Key)
+	}
+	return sysctl, nil
+}
+
+/*
+Return the path of the kernel parameter under /proc/sys
+*/
+func (sysctl *Sysctl) procPath() string {
+	return filepath.Join(procSysPath, strings.Replace(sysctl.Key, ".", "/", -1))
+}
+
+/*
+Read the current runtime value of the kernel parameter
+*/
+func (sysctl *Sysctl) runtimeValue() (string, error) {
+	data, err := ioutil.ReadFile(sysctl.procPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("Unknown kernel parameter: %s", sysctl.Key)
+		}
+		return "", err
+	}
+	return normalizeSysctlValue(string(data)), nil
+}
+
+/*
+Set the runtime value of the kernel parameter
+*/
+func (sysctl *Sysctl) setRuntimeValue() error {
+	log.Printf("Setting kernel parameter %s = %s", sysctl.Key, sysctl.Value)
+	return ioutil.WriteFile(sysctl.procPath(), []byte(sysctl.Value+"\n"), 0644)
+}
+
+/*
+Read the value of the kernel parameter from its persistent file, the last matching entry wins
+*/
+func (sysctl *Sysctl) persistedValue() (string, error) {
+	data, err := ioutil.ReadFile(sysctl.File)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	value := ""
+	for _, line := range strings.Split(string(data), "\n") {
+		key, v, ok := parseSysctlLine(line)
+		if ok && key == sysctl.Key {
+			value = v
+		}
+	}
+	return value, nil
+}
+
+/*
+Persist the kernel parameter, replacing any existing entries for it
+*/
+func (sysctl *Sysctl) persistValue() error {
+	lines := make([]string, 0)
+	data, err := ioutil.ReadFile(sysctl.File)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	written := false
+	entry := fmt.Sprintf("%s = %s", sysctl.Key, sysctl.Value)
+	if len(data) > 0 {
+		for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+			key, _, ok := parseSysctlLine(line)
+			if ok && key == sysctl.Key {
+				if !written {
+					lines = append(lines, entry)
+					written = true
+				}
+				continue
+			}
+			lines = append(lines, line)
+		}
+	}
+	if !written {
+		lines = append(lines, entry)
+	}
+	err = os.MkdirAll(filepath.Dir(sysctl.File), 0755)
+	if err != nil {
+		return err
+	}
+	log.Printf("Persisting kernel parameter %s to %s", sysctl.Key, sysctl.File)
+	return ioutil.WriteFile(sysctl.File, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+/*
+Parse a single "key = value" line from a sysctl.d file
+*/
+func parseSysctlLine(line string) (string, string, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+		return "", "", false
+	}
+	split := strings.SplitN(line, "=", 2)
+	if len(split) != 2 {
+		return "", "", false
+	}
+	key := strings.TrimPrefix(strings.TrimSpace(split[0]), "-")
+	key = strings.Replace(key, "/", ".", -1)
+	return key, normalizeSysctlValue(split[1]), true
+}
+
+/*
+Collapse the whitespace in a value, multi-value parameters are separated by tabs in /proc/sys
+*/
+func normalizeSysctlValue(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
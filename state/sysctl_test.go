@@ -0,0 +1,79 @@
+package state
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var sysctlMeta = Metadata{
+	Name:  "net.ipv4.ip_forward",
+	Type:  "sysctl",
+	State: "present",
+}
+
+/*
+Point the proc sys path at a temporary directory, the returned function restores it
+*/
+func sysctlSetup(runtime, persisted string, t *testing.T) (State, string, func()) {
+	dir, err := ioutil.TempDir("", "otter-sysctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldProcSys := procSysPath
+	restore := func() {
+		procSysPath = oldProcSys
+		os.RemoveAll(dir)
+	}
+	procSysPath = filepath.Join(dir, "proc")
+	os.MkdirAll(filepath.Join(procSysPath, "net", "ipv4"), 0755)
+	ioutil.WriteFile(filepath.Join(procSysPath, "net", "ipv4", "ip_forward"), []byte(runtime), 0644)
+	file := filepath.Join(dir, "sysctl.d", "99-otter.conf")
+	if persisted != "" {
+		os.MkdirAll(filepath.Dir(file), 0755)
+		ioutil.WriteFile(file, []byte(persisted), 0644)
+	}
+	return stateSetup(sysctlMeta, []byte(fmt.Sprintf(`{"value": "1", "file": "%s"}`, file)), t), dir, restore
+}
+
+func TestSysctlRuntimeDrift(t *testing.T) {
+	state, _, restore := sysctlSetup("0\n", "# forwarding\nnet.ipv4.ip_forward = 1\n", t)
+	defer restore()
+	result := state.State()
+	if result.Consistent || result.Details["runtime"] != "0" || result.Details["persisted"] != "1" {
+		fmt.Println("Failed to detect runtime drift: ", result.Message)
+		t.Fail()
+	}
+}
+
+func TestSysctlApply(t *testing.T) {
+	state, dir, restore := sysctlSetup("0\n", "net.ipv4.ip_forward=0\nvm.swappiness = 10\n", t)
+	defer restore()
+	result := state.Apply()
+	if !result.Consistent {
+		fmt.Println("Failed to apply kernel parameter: ", result.Message)
+		t.Fail()
+	}
+	data, _ := ioutil.ReadFile(filepath.Join(dir, "sysctl.d", "99-otter.conf"))
+	if string(data) != "net.ipv4.ip_forward = 1\nvm.swappiness = 10\n" {
+		fmt.Println("Bad persisted kernel parameters: ", string(data))
+		t.Fail()
+	}
+	if !state.State().Consistent {
+		fmt.Println("Kernel parameter not consistent after apply")
+		t.Fail()
+	}
+}
+
+func TestSysctlUnknownParameter(t *testing.T) {
+	state, _, restore := sysctlSetup("1\n", "", t)
+	defer restore()
+	state.(*Sysctl).Key = "net.ipv4.no_exist"
+	result := state.State()
+	if result.Consistent {
+		fmt.Println("Failed to detect unknown kernel parameter")
+		t.Fail()
+	}
+}
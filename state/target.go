@@ -0,0 +1,114 @@
+package state
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+)
+
+/*
+A Target maps states to the hosts which should receive them.
+A host matches when every specified matcher matches: any of the hostname globs, the regular expression and every fact.
+*/
+type Target struct {
+	Name   string            `yaml:"-"`
+	Hosts  []string          `yaml:"hosts"`  // Hostname globs such as "worker-*"
+	Regex  string            `yaml:"regex"`  // Regular expression matched against the hostname
+	Facts  map[string]string `yaml:"facts"`  // Facts published by the host such as "family" or a "role" label
+	States []string          `yaml:"states"` // Names of the states applied to matching hosts
+	regex  *regexp.Regexp
+}
+
+/*
+Check if a host with the given facts is targeted
+*/
+func (target *Target) Matches(host string, facts map[string]string) bool {
+	if len(target.Hosts) > 0 {
+		matched := false
+		for _, pattern := range target.Hosts {
+			if ok, _ := path.Match(pattern, host); ok {
+				matched = true
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if target.regex != nil && !target.regex.MatchString(host) {
+		return false
+	}
+	for key, value := range target.Facts {
+		if facts[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Validate a target and compile its regular expression
+*/
+func (target *Target) compile() error {
+	for _, pattern := range target.Hosts {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Invalid host pattern %q in target %s: %s", pattern, target.Name, err)
+		}
+	}
+	if target.Regex != "" {
+		regex, err := regexp.Compile(target.Regex)
+		if err != nil {
+			return fmt.Errorf("Invalid regex in target %s: %s", target.Name, err)
+		}
+		target.regex = regex
+	}
+	if len(target.States) == 0 {
+		return fmt.Errorf("No states specified for target %s", target.Name)
+	}
+	return nil
+}
+
+/*
+Return a StateMap of the states applied to a host.
+States which are not listed by any target are applied to every host, states whose when expression is false on the host
+are kept so they are reported as skipped.
+*/
+func (sm *StateMap) ForHost(host string, facts map[string]string) (*StateMap, error) {
+	targeted := make(map[string]bool)
+	matched := make(map[string]bool)
+	for _, target := range sm.Targets {
+		matches := target.Matches(host, facts)
+		for _, name := range target.States {
+			targeted[name] = true
+			if matches {
+				matched[name] = true
+			}
+		}
+	}
+	hostMap := NewStateMap()
+	for _, state := range sm.States {
+		md := state.Meta()
+		if targeted[md.Name] && !matched[md.Name] {
+			continue
+		}
+		for _, requirement := range md.Requirements {
+			for _, required := range sm.States {
+				metadata := required.Meta()
+				if metadata.Satisfies(requirement) && targeted[metadata.Name] && !matched[metadata.Name] {
+					return nil, md.Errorf("State %s on host %s requires %s which is not targeted to it", md.Name, host, requirement)
+				}
+			}
+		}
+		if md.When != "" {
+			applies, err := evaluateWhen(md.When, facts, sm.Vars)
+			if err != nil {
+				return nil, md.Errorf("%s", err)
+			}
+			if !applies {
+				state = &skippedState{Metadata: md, Skipped: true}
+			}
+		}
+		hostMap.States = append(hostMap.States, state)
+	}
+	hostMap.Vars = sm.Vars
+	return hostMap, nil
+}
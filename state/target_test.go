@@ -0,0 +1,74 @@
+package state
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var targeted = []byte(`
+targets:
+  workers:
+    hosts: ["worker-*"]
+    facts:
+      role: worker
+    states: [kubelet]
+  debian:
+    regex: "^(worker|master)-\\d+$"
+    facts:
+      family: debian
+    states: [docker, kubelet]
+docker:
+  package.installed: {}
+kubelet:
+  package.installed:
+    require:
+      - docker
+net.ipv4.ip_forward:
+  sysctl.present:
+    value: "1"
+`)
+
+func hostStates(stateMap *StateMap, host string, facts map[string]string) string {
+	hostMap, err := stateMap.ForHost(host, facts)
+	if err != nil {
+		return err.Error()
+	}
+	names := make([]string, 0)
+	for _, state := range hostMap.States {
+		names = append(names, state.Meta().Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestTargets(t *testing.T) {
+	stateMap := loadStateMapFromYaml(targeted, t)
+	for _, test := range []struct {
+		host     string
+		facts    map[string]string
+		expected string
+	}{
+		{"worker-1", map[string]string{"family": "debian", "role": "worker"}, "docker,kubelet,net.ipv4.ip_forward"},
+		{"master-1", map[string]string{"family": "debian"}, "docker,kubelet,net.ipv4.ip_forward"},
+		{"worker-2", map[string]string{"family": "centos", "role": "worker"}, "line 16: State kubelet on host worker-2 requires docker which is not targeted to it"},
+		{"etcd-1", map[string]string{"family": "debian"}, "net.ipv4.ip_forward"},
+	} {
+		if states := hostStates(stateMap, test.host, test.facts); states != test.expected {
+			fmt.Println("Bad targeted states for ", test.host, ": ", states)
+			t.Fail()
+		}
+	}
+}
+
+func TestTargetsUnknownState(t *testing.T) {
+	_, err := StateMapFromYaml([]byte("targets:\n  workers:\n    hosts: [\"worker-*\"]\n    states: [kubelet]\n"))
+	if err == nil || err.Error() != "line 2: Target workers references unknown state kubelet" {
+		fmt.Println("Failed to detect unknown targeted state: ", err)
+		t.Fail()
+	}
+	_, err = StateMapFromYaml([]byte("targets:\n  workers:\n    regex: \"(\"\n    states: [kubelet]\n"))
+	if err == nil || !strings.HasPrefix(err.Error(), "line 3: Invalid regex in target workers") {
+		fmt.Println("Failed to detect invalid regex: ", err)
+		t.Fail()
+	}
+}
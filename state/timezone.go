@@ -0,0 +1,131 @@
+/*
+A Timezone represents the local time zone of an operating system, configured by the /etc/localtime symlink.
+States -
+  set: /etc/localtime links to the zone in the time zone database
+*/
+
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	localtimePath = "/etc/localtime"
+	zoneinfoPath  = "/usr/share/zoneinfo"
+)
+
+type Timezone struct {
+	Zone     string   `json:"zone"` // Time zone such as "UTC" or "Europe/Berlin", defaults to the state name
+	Metadata Metadata `json:"metadata"`
+}
+
+func (timezone *Timezone) Meta() Metadata {
+	return timezone.Metadata
+}
+
+func (timezone *Timezone) State() *Result {
+	result := &Result{
+		Metadata:   &timezone.Metadata,
+		Consistent: false,
+	}
+	if _, err := os.Stat(timezone.zonePath()); err != nil {
+		result.Message = fmt.Sprintf("Unknown time zone %s: %s", timezone.Zone, err)
+		return result
+	}
+	current, err := currentTimezone()
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	result.Details = map[string]string{"zone": current}
+	if current != timezone.Zone {
+		result.Message = fmt.Sprintf("Time zone is %q, expected %q", current, timezone.Zone)
+		return result
+	}
+	result.Consistent = true
+	return result
+}
+
+func (timezone *Timezone) Apply() *Result {
+	result := timezone.State()
+	if result.Consistent == true {
+		return result
+	}
+	if result.Details == nil { // The zone is unknown or /etc/localtime could not be read
+		return result
+	}
+	log.Printf("Linking %s to %s", localtimePath, timezone.zonePath())
+	tmp := localtimePath + ".otter"
+	os.Remove(tmp)
+	err := os.Symlink(timezone.zonePath(), tmp)
+	if err == nil {
+		err = os.Rename(tmp, localtimePath) // Replace the link atomically
+	}
+	if err != nil {
+		os.Remove(tmp)
+		result.Message = err.Error()
+		return result
+	}
+	result.Details["zone"] = timezone.Zone
+	result.Message = fmt.Sprintf("Time zone set to %s", timezone.Zone)
+	result.Consistent = true
+	return result
+}
+
+/*
+Create and validate a new Timezone State
+*/
+func newTimezone(metadata Metadata, data []byte) (*Timezone, error) {
+	timezone := &Timezone{}
+	err := json.Unmarshal(data, &timezone)
+	if err != nil {
+		return nil, err
+	}
+	timezone.Metadata = metadata
+	switch metadata.State {
+	case "set":
+	default:
+		return nil, fmt.Errorf("Invalid timezone state: %s", metadata.State)
+	}
+	if timezone.Zone == "" {
+		timezone.Zone = metadata.Name
+	}
+	if timezone.Zone == "" || filepath.IsAbs(timezone.Zone) || strings.Contains(timezone.Zone, "..") {
+		return nil, fmt.Errorf("Invalid time zone: %s", timezone.Zone)
+	}
+	return timezone, nil
+}
+
+func (timezone *Timezone) zonePath() string {
+	return filepath.Join(zoneinfoPath, timezone.Zone)
+}
+
+/*
+Return the zone /etc/localtime links to, or an empty string if it is not a link into the time zone database
+*/
+func currentTimezone() (string, error) {
+	target, err := os.Readlink(localtimePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		if info, statErr := os.Lstat(localtimePath); statErr == nil && info.Mode()&os.ModeSymlink == 0 {
+			return "", nil // A copy of a zone file rather than a link
+		}
+		return "", err
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(localtimePath), target)
+	}
+	zone, err := filepath.Rel(zoneinfoPath, filepath.Clean(target))
+	if err != nil || strings.HasPrefix(zone, "..") {
+		return "", nil
+	}
+	return zone, nil
+}
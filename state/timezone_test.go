@@ -0,0 +1,42 @@
+package state
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTimezoneSet(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "otter-timezone")
+	defer os.RemoveAll(dir)
+	oldZoneinfo, oldLocaltime := zoneinfoPath, localtimePath
+	defer func() { zoneinfoPath, localtimePath = oldZoneinfo, oldLocaltime }()
+	zoneinfoPath = filepath.Join(dir, "zoneinfo")
+	localtimePath = filepath.Join(dir, "localtime")
+	os.MkdirAll(filepath.Join(zoneinfoPath, "Europe"), 0755)
+	ioutil.WriteFile(filepath.Join(zoneinfoPath, "UTC"), []byte("TZif"), 0644)
+	ioutil.WriteFile(filepath.Join(zoneinfoPath, "Europe", "Berlin"), []byte("TZif"), 0644)
+	os.Symlink("zoneinfo/UTC", localtimePath)
+	state := stateSetup(Metadata{Name: "Europe/Berlin", Type: "timezone", State: "set"}, []byte(`{}`), t)
+	result := state.State()
+	if result.Consistent || result.Details["zone"] != "UTC" {
+		fmt.Println("Failed to detect time zone drift: ", result.Message)
+		t.Fail()
+	}
+	state.Apply()
+	if target, _ := os.Readlink(localtimePath); target != filepath.Join(zoneinfoPath, "Europe", "Berlin") {
+		fmt.Println("Bad localtime link: ", target)
+		t.Fail()
+	}
+	if !state.State().Consistent {
+		fmt.Println("Time zone should be set")
+		t.Fail()
+	}
+	state = stateSetup(Metadata{Name: "Mars/Olympus", Type: "timezone", State: "set"}, []byte(`{}`), t)
+	if state.Apply().Consistent {
+		fmt.Println("Unknown time zone should fail")
+		t.Fail()
+	}
+}
@@ -0,0 +1,280 @@
+/*
+A User represents a user account on an operating system.
+States -
+  present: The user exists in /etc/passwd with the declared attributes
+  absent: The user does not exist in /etc/passwd
+*/
+
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+var passwdPath = "/etc/passwd"
+
+type User struct {
+	Username string   `json:"username"` // Name of the user, defaults to the state name
+	UID      string   `json:"uid"`      // Numeric user id
+	GID      string   `json:"gid"`      // Primary group name or numeric group id
+	Home     string   `json:"home"`     // Home directory
+	Shell    string   `json:"shell"`    // Login shell
+	Groups   []string `json:"groups"`   // Supplementary groups the user is a member of
+	System   bool     `json:"system"`   // Create the user as a system account
+	Metadata Metadata `json:"metadata"`
+}
+
+type passwdEntry struct {
+	Name  string
+	UID   string
+	GID   string
+	Home  string
+	Shell string
+}
+
+func (user *User) Meta() Metadata {
+	return user.Metadata
+}
+
+func (user *User) State() *Result {
+	result := &Result{
+		Metadata:   &user.Metadata,
+		Consistent: false,
+	}
+	users, groups, err := readAccounts()
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	switch user.Metadata.State {
+	case "present":
+		differences := user.differences(users, groups)
+		if len(differences) > 0 {
+			result.Message = strings.Join(differences, ", ")
+			return result
+		}
+	case "absent":
+		if _, exists := users[user.Username]; exists {
+			result.Message = fmt.Sprintf("User %s exists", user.Username)
+			return result
+		}
+	}
+	result.Consistent = true
+	return result
+}
+
+func (user *User) Apply() *Result {
+	result := user.State()
+	if result.Consistent == true {
+		return result
+	}
+	switch user.Metadata.State {
+	case "present":
+		users, groups, err := readAccounts()
+		if err != nil {
+			result.Message = err.Error()
+			return result
+		}
+		if _, exists := users[user.Username]; exists {
+			err = runCommand("usermod", user.usermodArgs(users, groups)...)
+		} else {
+			err = runCommand("useradd", user.useraddArgs()...)
+		}
+		if err != nil {
+			result.Message = err.Error()
+			return result
+		}
+		result.Message = "User present"
+		result.Consistent = true
+	case "absent":
+		err := runCommand("userdel", user.Username)
+		if err != nil {
+			result.Message = err.Error()
+			return result
+		}
+		result.Message = "User removed"
+		result.Consistent = true
+	}
+	return result
+}
+
+/*
+Create and validate a new User State
+*/
+func newUser(metadata Metadata, data []byte) (*User, error) {
+	user := &User{}
+	err := json.Unmarshal(data, &user)
+	if err != nil {
+		return nil, err
+	}
+	user.Metadata = metadata
+	switch metadata.State {
+	case "present":
+	case "absent":
+	default:
+		return nil, fmt.Errorf("Invalid user state: %s", metadata.State)
+	}
+	if user.Username == "" {
+		user.Username = metadata.Name
+	}
+	if user.UID != "" && !isNumeric(user.UID) {
+		return nil, fmt.Errorf("Invalid uid for user %s: %s", user.Username, user.UID)
+	}
+	return user, nil
+}
+
+/*
+Compare the declared user with the account databases and describe each difference
+*/
+func (user *User) differences(users map[string]passwdEntry, groups map[string]groupEntry) []string {
+	differences := make([]string, 0)
+	entry, exists := users[user.Username]
+	if !exists {
+		return append(differences, fmt.Sprintf("User %s does not exist", user.Username))
+	}
+	if user.UID != "" && entry.UID != user.UID {
+		differences = append(differences, fmt.Sprintf("uid is %s, expected %s", entry.UID, user.UID))
+	}
+	if user.GID != "" {
+		gid := user.GID
+		if !isNumeric(gid) {
+			group, exists := groups[gid]
+			if !exists {
+				differences = append(differences, fmt.Sprintf("Primary group %s does not exist", gid))
+			}
+			gid = group.GID
+		}
+		if gid != "" && entry.GID != gid {
+			differences = append(differences, fmt.Sprintf("gid is %s, expected %s", entry.GID, gid))
+		}
+	}
+	if user.Home != "" && entry.Home != user.Home {
+		differences = append(differences, fmt.Sprintf("home is %s, expected %s", entry.Home, user.Home))
+	}
+	if user.Shell != "" && entry.Shell != user.Shell {
+		differences = append(differences, fmt.Sprintf("shell is %s, expected %s", entry.Shell, user.Shell))
+	}
+	for _, name := range user.missingGroups(groups) {
+		differences = append(differences, fmt.Sprintf("Not a member of group %s", name))
+	}
+	return differences
+}
+
+/*
+List the supplementary groups the user is not yet a member of
+*/
+func (user *User) missingGroups(groups map[string]groupEntry) []string {
+	missing := make([]string, 0)
+	for _, name := range user.Groups {
+		member := false
+		for _, m := range groups[name].Members {
+			if m == user.Username {
+				member = true
+			}
+		}
+		if !member {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+/*
+Build the arguments passed to useradd
+*/
+func (user *User) useraddArgs() []string {
+	args := make([]string, 0)
+	if user.UID != "" {
+		args = append(args, "-u", user.UID)
+	}
+	if user.GID != "" {
+		args = append(args, "-g", user.GID)
+	}
+	if user.Home != "" {
+		args = append(args, "-d", user.Home)
+	}
+	if !user.System {
+		args = append(args, "-m")
+	}
+	if user.Shell != "" {
+		args = append(args, "-s", user.Shell)
+	}
+	if len(user.Groups) > 0 {
+		args = append(args, "-G", strings.Join(user.Groups, ","))
+	}
+	if user.System {
+		args = append(args, "-r")
+	}
+	return append(args, user.Username)
+}
+
+/*
+Build the arguments passed to usermod, only attributes which differ are changed
+*/
+func (user *User) usermodArgs(users map[string]passwdEntry, groups map[string]groupEntry) []string {
+	args := make([]string, 0)
+	entry := users[user.Username]
+	if user.UID != "" && entry.UID != user.UID {
+		args = append(args, "-u", user.UID)
+	}
+	if user.GID != "" && entry.GID != user.GID && groups[user.GID].GID != entry.GID {
+		args = append(args, "-g", user.GID)
+	}
+	if user.Home != "" && entry.Home != user.Home {
+		args = append(args, "-d", user.Home)
+	}
+	if user.Shell != "" && entry.Shell != user.Shell {
+		args = append(args, "-s", user.Shell)
+	}
+	if missing := user.missingGroups(groups); len(missing) > 0 {
+		args = append(args, "-a", "-G", strings.Join(missing, ","))
+	}
+	return append(args, user.Username)
+}
+
+/*
+Read and parse both the passwd and group databases
+*/
+func readAccounts() (map[string]passwdEntry, map[string]groupEntry, error) {
+	data, err := ioutil.ReadFile(passwdPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	users, err := parsePasswd(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	groups, err := readGroups()
+	if err != nil {
+		return nil, nil, err
+	}
+	return users, groups, nil
+}
+
+/*
+Parse the contents of an /etc/passwd formatted file into a map of user names to entries
+*/
+func parsePasswd(data []byte) (map[string]passwdEntry, error) {
+	users := make(map[string]passwdEntry)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "+") || strings.HasPrefix(line, "-") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) != 7 || fields[0] == "" || !isNumeric(fields[2]) || !isNumeric(fields[3]) {
+			return nil, fmt.Errorf("Malformed passwd entry on line %d: %s", i+1, line)
+		}
+		users[fields[0]] = passwdEntry{
+			Name:  fields[0],
+			UID:   fields[2],
+			GID:   fields[3],
+			Home:  fields[5],
+			Shell: fields[6],
+		}
+	}
+	return users, nil
+}
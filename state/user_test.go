@@ -0,0 +1,99 @@
+package state
+
+import (
+	"fmt"
+	"testing"
+)
+
+var passwdFile = []byte(`
+root:x:0:0:root:/root:/bin/bash
+deploy:x:1001:1001:Deploy User:/home/deploy:/bin/sh
+
+# comment lines are ignored
++nisuser
+`)
+
+var simpleUserMeta = Metadata{
+	Name:  "deploy",
+	Type:  "user",
+	State: "present",
+}
+
+func userSetup(data string, t *testing.T) (*User, map[string]passwdEntry, map[string]groupEntry) {
+	state := stateSetup(simpleUserMeta, []byte(data), t)
+	users, err := parsePasswd(passwdFile)
+	if err != nil {
+		fmt.Println("Failed to parse passwd file: ", err)
+		t.FailNow()
+	}
+	groups, err := parseGroup(groupFile)
+	if err != nil {
+		fmt.Println("Failed to parse group file: ", err)
+		t.FailNow()
+	}
+	return state.(*User), users, groups
+}
+
+func TestParsePasswd(t *testing.T) {
+	users, err := parsePasswd(passwdFile)
+	if err != nil {
+		fmt.Println("Failed to parse passwd file: ", err)
+		t.FailNow()
+	}
+	if len(users) != 2 {
+		fmt.Println("Did not parse correct amount of users: ", len(users))
+		t.Fail()
+	}
+	deploy := users["deploy"]
+	if deploy.UID != "1001" || deploy.GID != "1001" || deploy.Home != "/home/deploy" || deploy.Shell != "/bin/sh" {
+		fmt.Println("Bad passwd entry: ", deploy)
+		t.Fail()
+	}
+}
+
+func TestParsePasswdMalformed(t *testing.T) {
+	for _, data := range []string{"deploy:x:1001:1001::/home/deploy", "deploy:x:uid:1001::/home/deploy:/bin/sh"} {
+		_, err := parsePasswd([]byte(data))
+		if err == nil {
+			fmt.Println("Failed to detect malformed passwd entry: ", data)
+			t.Fail()
+		}
+	}
+}
+
+func TestUserConsistent(t *testing.T) {
+	user, users, groups := userSetup(`{"uid": "1001", "gid": "deploy", "home": "/home/deploy", "groups": ["docker"]}`, t)
+	differences := user.differences(users, groups)
+	if len(differences) != 0 {
+		fmt.Println("Detected differences for consistent user: ", differences)
+		t.Fail()
+	}
+}
+
+func TestUserDifferences(t *testing.T) {
+	user, users, groups := userSetup(`{"uid": "1002", "gid": "docker", "shell": "/bin/bash", "groups": ["docker", "root"]}`, t)
+	differences := user.differences(users, groups)
+	if len(differences) != 4 {
+		fmt.Println("Did not detect correct amount of differences: ", differences)
+		t.Fail()
+	}
+	args := fmt.Sprint(user.usermodArgs(users, groups))
+	if args != "[-u 1002 -g docker -s /bin/bash -a -G root deploy]" {
+		fmt.Println("Bad usermod arguments: ", args)
+		t.Fail()
+	}
+}
+
+func TestUserMissing(t *testing.T) {
+	user, users, groups := userSetup(`{"system": true, "shell": "/usr/sbin/nologin"}`, t)
+	delete(users, "deploy")
+	if len(user.differences(users, groups)) != 1 {
+		fmt.Println("Failed to detect missing user")
+		t.Fail()
+	}
+	args := fmt.Sprint(user.useraddArgs())
+	if args != "[-s /usr/sbin/nologin -r deploy]" {
+		fmt.Println("Bad useradd arguments: ", args)
+		t.Fail()
+	}
+}
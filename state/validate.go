@@ -0,0 +1,145 @@
+package state
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+/*
+A ValidationError is a problem found while loading a state file along with the position it was found at
+*/
+type ValidationError struct {
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+func (err *ValidationError) Error() string {
+	switch {
+	case err.File != "" && err.Line != 0:
+		return fmt.Sprintf("%s:%d: %s", err.File, err.Line, err.Message)
+	case err.File != "":
+		return fmt.Sprintf("%s: %s", err.File, err.Message)
+	case err.Line != 0:
+		return fmt.Sprintf("line %d: %s", err.Line, err.Message)
+	}
+	return err.Message
+}
+
+/*
+ValidationErrors are every problem found while loading a state file
+*/
+type ValidationErrors []*ValidationError
+
+func (errs ValidationErrors) Error() string {
+	messages := make([]string, 0)
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "\n")
+}
+
+/*
+Return the validation errors of an error returned while loading states, errors without a position are reported against
+the file they were loaded from
+*/
+func AsValidationErrors(err error, file string) ValidationErrors {
+	switch err := err.(type) {
+	case ValidationErrors:
+		return err
+	case *ValidationError:
+		return ValidationErrors{err}
+	}
+	return ValidationErrors{&ValidationError{File: file, Message: err.Error()}}
+}
+
+/*
+Return the keys accepted in the data of a state, the JSON names of its fields, "require" and "when"
+*/
+func stateKeys(state State) []string {
+	keys := []string{"require", "when"}
+	value := reflect.Indirect(reflect.ValueOf(state))
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if field.PkgPath != "" || field.Type == reflect.TypeOf(Metadata{}) {
+			continue // Unexported fields and Metadata can not be set from a state file
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = field.Name
+		}
+		keys = append(keys, name)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+/*
+Check a key against the keys a state accepts, keys must match exactly and the closest key is returned as a suggestion
+if it is unknown
+*/
+func unknownKey(key string, keys []string) (bool, string) {
+	for _, known := range keys {
+		if key == known {
+			return false, ""
+		}
+	}
+	return true, suggest(key, keys)
+}
+
+/*
+Return a hint to append to an error message for a suggestion
+*/
+func didYouMean(suggestion string) string {
+	if suggestion == "" {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %s?", suggestion)
+}
+
+/*
+Return the candidate closest to a misspelled word, an empty string if none are close enough to be a likely typo
+*/
+func suggest(word string, candidates []string) string {
+	best, distance := "", len(word)/3+2
+	for _, candidate := range candidates {
+		if d := editDistance(strings.ToLower(word), strings.ToLower(candidate)); d < distance {
+			best, distance = candidate, d
+		}
+	}
+	return best
+}
+
+/*
+Return the Levenshtein distance between two strings
+*/
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
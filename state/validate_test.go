@@ -0,0 +1,58 @@
+package state
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var misspelled = []byte(`
+docker:
+  package.installed:
+    verison: 1.12.6
+  servce.running: {}
+kubelet:
+  file.rendered:
+    path: /etc/kubelet
+    moed: "0644"
+    xyzzy: true
+    Mode: "0644"
+`)
+
+func TestValidate(t *testing.T) {
+	_, err := StateMapFromYaml(misspelled)
+	errs := AsValidationErrors(err, "")
+	expected := []string{
+		"line 4: Unknown key verison in package.installed, did you mean version?",
+		"line 5: Unknown state type servce, did you mean service?",
+		"line 9: Unknown key moed in file.rendered, did you mean mode?",
+		"line 10: Unknown key xyzzy in file.rendered",
+		"line 11: Unknown key Mode in file.rendered, did you mean mode?",
+	}
+	if err == nil || errs.Error() != strings.Join(expected, "\n") {
+		fmt.Println("Bad validation errors: ", err)
+		t.Fail()
+	}
+	for _, key := range []string{"verison", "servce", "moed"} {
+		for _, err := range errs {
+			if strings.Contains(err.Message, key) && err.Line == 0 {
+				fmt.Println("Validation error has no position: ", err)
+				t.Fail()
+			}
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	for word, expected := range map[string]string{
+		"verison": "version",
+		"Path":    "path",
+		"packge":  "package",
+		"zzzzzzz": "",
+	} {
+		if suggestion := suggest(word, []string{"package", "path", "version"}); suggestion != expected {
+			fmt.Println("Bad suggestion for ", word, ": ", suggestion)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package state
+
+import (
+	"bytes"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"strings"
+	"text/template"
+)
+
+/*
+Vars are substituted into the string fields of states with Go templates, e.g. "{{ .docker_version }}".
+Variables are layered, those declared in vars: sections are overridden by a vars file which is overridden by the command line.
+*/
+type Vars map[string]interface{}
+
+/*
+Merge other variables into these, other variables take precedence
+*/
+func (vars Vars) Merge(other Vars) {
+	for key, value := range other {
+		vars[key] = value
+	}
+}
+
+/*
+Load variables from a YAML file containing a mapping of variable names to values
+*/
+func VarsFromYamlPath(path string) (Vars, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	vars := make(Vars)
+	err = yaml.Unmarshal(data, &vars)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return vars, nil
+}
+
+/*
+Parse variables from a list of key=value pairs
+*/
+func VarsFromPairs(pairs []string) (Vars, error) {
+	vars := make(Vars)
+	for _, pair := range pairs {
+		split := strings.SplitN(pair, "=", 2)
+		if len(split) != 2 || split[0] == "" {
+			return nil, fmt.Errorf("Invalid variable %q, expected key=value", pair)
+		}
+		vars[split[0]] = split[1]
+	}
+	return vars, nil
+}
+
+/*
+Load the variables of a vars: section, later sections override earlier ones
+*/
+func (loader *yamlLoader) loadVars(file string, value *yaml.Node) error {
+	if value.Kind != yaml.MappingNode {
+		return loader.errorf(file, value, "Expected a mapping of variables")
+	}
+	vars := make(Vars)
+	err := value.Decode(&vars)
+	if err != nil {
+		return loader.errorf(file, value, "%s", err)
+	}
+	loader.vars.Merge(vars)
+	return nil
+}
+
+/*
+Return a copy of a node with variables substituted into every string scalar, the type of a substituted scalar is
+resolved from its value as if it was written unquoted.
+Referencing an undefined variable is an error, the node which failed is returned with it.
+*/
+func substituteVars(node *yaml.Node, vars Vars) (*yaml.Node, *yaml.Node, error) {
+	substituted := *node
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if node.Tag != "!!str" || !strings.Contains(node.Value, "{{") {
+			return &substituted, nil, nil
+		}
+		tmpl, err := template.New("").Option("missingkey=error").Parse(node.Value)
+		if err != nil {
+			return nil, node, fmt.Errorf("Invalid variable reference in %q: %s", node.Value, err)
+		}
+		var buf bytes.Buffer
+		err = tmpl.Execute(&buf, vars)
+		if err != nil {
+			return nil, node, fmt.Errorf("Undefined variable in %q: %s", node.Value, err)
+		}
+		substituted.Value = buf.String()
+		substituted.Tag, substituted.Style = "", 0 // Resolve the type of the substituted value, e.g. "{{ .bits }}" may be an int
+	case yaml.MappingNode, yaml.SequenceNode, yaml.DocumentNode:
+		substituted.Content = make([]*yaml.Node, len(node.Content))
+		for i, child := range node.Content {
+			if node.Kind == yaml.MappingNode && i%2 == 0 {
+				substituted.Content[i] = child // Keys are not substituted
+				continue
+			}
+			result, failed, err := substituteVars(child, vars)
+			if err != nil {
+				return nil, failed, err
+			}
+			substituted.Content[i] = result
+		}
+	}
+	return &substituted, nil, nil
+}
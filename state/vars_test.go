@@ -0,0 +1,117 @@
+package state
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var varsState = `
+vars:
+  docker_version: 1.12.6
+  sysctl:
+    value: "0"
+docker:
+  package.installed:
+    version: "{{ .docker_version }}"
+net.ipv4.ip_forward:
+  sysctl.present:
+    value: "{{ .sysctl.value }}"
+`
+
+func TestVarsPrecedence(t *testing.T) {
+	dir := includeSetup(map[string]string{
+		"otter.yaml":      varsState,
+		"production.yaml": "docker_version: 1.13.1\nsysctl:\n  value: \"1\"\n",
+	}, t)
+	defer os.RemoveAll(dir)
+	for _, test := range []struct {
+		file     string
+		pairs    []string
+		expected string
+	}{
+		{"", nil, "1.12.6 0"},
+		{"production.yaml", nil, "1.13.1 1"},
+		{"production.yaml", []string{"docker_version=17.03.0"}, "17.03.0 1"},
+	} {
+		vars := make(Vars)
+		if test.file != "" {
+			fileVars, err := VarsFromYamlPath(filepath.Join(dir, test.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			vars.Merge(fileVars)
+		}
+		pairs, _ := VarsFromPairs(test.pairs)
+		vars.Merge(pairs)
+		stateMap, err := StateMapFromYamlPathWithVars(filepath.Join(dir, "otter.yaml"), vars)
+		if err != nil {
+			fmt.Println("Failed to load state with variables: ", err)
+			t.FailNow()
+		}
+		result := fmt.Sprintf("%s %s", stateMap.States[0].(*Package).Version, stateMap.States[1].(*Sysctl).Value)
+		if result != test.expected {
+			fmt.Println("Bad variable substitution: ", result)
+			t.Fail()
+		}
+	}
+}
+
+func TestVarsUndefined(t *testing.T) {
+	_, err := StateMapFromYaml([]byte("docker:\n  package.installed:\n    version: \"{{ .docker_version }}\"\n"))
+	if err == nil || !strings.HasPrefix(err.Error(), "line 3: Undefined variable") {
+		fmt.Println("Failed to detect undefined variable: ", err)
+		t.Fail()
+	}
+}
+
+func TestVarsFromPairs(t *testing.T) {
+	vars, err := VarsFromPairs([]string{"registry=https://registry.local:5000/v2?a=b"})
+	if err != nil || vars["registry"] != "https://registry.local:5000/v2?a=b" {
+		fmt.Println("Bad variable pair: ", vars, err)
+		t.Fail()
+	}
+	if _, err := VarsFromPairs([]string{"registry"}); err == nil {
+		fmt.Println("Variable without a value should fail")
+		t.Fail()
+	}
+	dir, _ := ioutil.TempDir("", "otter-vars")
+	defer os.RemoveAll(dir)
+	if _, err := VarsFromYamlPath(filepath.Join(dir, "missing.yaml")); err == nil {
+		fmt.Println("Missing vars file should fail")
+		t.Fail()
+	}
+}
+
+func TestVarsTypes(t *testing.T) {
+	stateMap, err := StateMapFromYaml([]byte(`
+vars:
+  position: 2
+  system: true
+  gid: 999
+kube-apiserver:
+  iptables.present:
+    chain: INPUT
+    rule: -p tcp --dport 6443 -j ACCEPT
+    position: "{{ .position }}"
+docker:
+  group.present:
+    gid: "{{ .gid }}"
+    system: "{{ .system }}"
+`))
+	if err != nil {
+		fmt.Println("Failed to substitute typed variables: ", err)
+		t.FailNow()
+	}
+	if position := stateMap.States[0].(*Iptables).Position; position != 2 {
+		fmt.Println("Bad substituted int: ", position)
+		t.Fail()
+	}
+	if group := stateMap.States[1].(*Group); !group.System || group.GID != "999" {
+		fmt.Println("Bad substituted bool or string: ", group)
+		t.Fail()
+	}
+}
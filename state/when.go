@@ -0,0 +1,268 @@
+package state
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+/*
+A whenExpression decides whether a state applies to a host, it is parsed from the "when" key of a state.
+Operands are quoted strings, true, false and references to "facts.<name>", "distro.family", "distro.init" or
+"vars.<name>". Operands may be compared with == and != and combined with !, && and || and parentheses.
+*/
+type whenExpression func(facts map[string]string, vars Vars) (string, error)
+
+/*
+Parse a when expression
+*/
+func parseWhen(expression string) (whenExpression, error) {
+	parser := &whenParser{tokens: tokenizeWhen(expression)}
+	expr, err := parser.or()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid when expression %q: %s", expression, err)
+	}
+	if parser.peek() != "" {
+		return nil, fmt.Errorf("Invalid when expression %q: unexpected %s", expression, parser.peek())
+	}
+	return expr, nil
+}
+
+/*
+Evaluate a when expression against the facts of a host and the variables of the state file
+*/
+func evaluateWhen(expression string, facts map[string]string, vars Vars) (bool, error) {
+	expr, err := parseWhen(expression)
+	if err != nil {
+		return false, err
+	}
+	value, err := expr(facts, vars)
+	if err != nil {
+		return false, fmt.Errorf("Unable to evaluate when expression %q: %s", expression, err)
+	}
+	return truthy(value), nil
+}
+
+func truthy(value string) bool {
+	return value != "" && value != "false"
+}
+
+func boolString(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
+
+var whenOperators = map[string]bool{"==": true, "!=": true, "&&": true, "||": true}
+
+/*
+Split an expression into quoted strings, operators, parentheses and identifiers
+*/
+func tokenizeWhen(expression string) []string {
+	tokens := make([]string, 0)
+	runes := []rune(expression)
+	for i := 0; i < len(runes); {
+		switch r := runes[i]; {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"' || r == '\'':
+			j := i + 1
+			for j < len(runes) && runes[j] != r {
+				j++
+			}
+			if j < len(runes) {
+				j++ // Include the closing quote, an unterminated string is reported by the parser
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+		case i+1 < len(runes) && whenOperators[string(runes[i:i+2])]:
+			tokens = append(tokens, string(runes[i:i+2]))
+			i += 2
+		case strings.ContainsRune("!()", r):
+			tokens = append(tokens, string(r))
+			i++
+		default:
+			j := i
+			for j < len(runes) && !unicode.IsSpace(runes[j]) && !strings.ContainsRune("\"'=!&|()", runes[j]) {
+				j++
+			}
+			if j == i {
+				j++ // A lone operator character, reported as unexpected by the parser
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+		}
+	}
+	return tokens
+}
+
+type whenParser struct {
+	tokens []string
+}
+
+func (parser *whenParser) peek() string {
+	if len(parser.tokens) == 0 {
+		return ""
+	}
+	return parser.tokens[0]
+}
+
+func (parser *whenParser) next() string {
+	token := parser.peek()
+	if token != "" {
+		parser.tokens = parser.tokens[1:]
+	}
+	return token
+}
+
+func (parser *whenParser) or() (whenExpression, error) {
+	left, err := parser.and()
+	for err == nil && parser.peek() == "||" {
+		parser.next()
+		var right whenExpression
+		right, err = parser.and()
+		left = combine(left, right, func(a, b string) bool { return truthy(a) || truthy(b) })
+	}
+	return left, err
+}
+
+func (parser *whenParser) and() (whenExpression, error) {
+	left, err := parser.unary()
+	for err == nil && parser.peek() == "&&" {
+		parser.next()
+		var right whenExpression
+		right, err = parser.unary()
+		left = combine(left, right, func(a, b string) bool { return truthy(a) && truthy(b) })
+	}
+	return left, err
+}
+
+func (parser *whenParser) unary() (whenExpression, error) {
+	if parser.peek() != "!" {
+		return parser.comparison()
+	}
+	parser.next()
+	operand, err := parser.unary()
+	if err != nil {
+		return nil, err
+	}
+	return func(facts map[string]string, vars Vars) (string, error) {
+		value, err := operand(facts, vars)
+		return boolString(!truthy(value)), err
+	}, nil
+}
+
+func (parser *whenParser) comparison() (whenExpression, error) {
+	left, err := parser.operand()
+	if err != nil {
+		return nil, err
+	}
+	switch parser.peek() {
+	case "==":
+		parser.next()
+		right, err := parser.operand()
+		return combine(left, right, func(a, b string) bool { return a == b }), err
+	case "!=":
+		parser.next()
+		right, err := parser.operand()
+		return combine(left, right, func(a, b string) bool { return a != b }), err
+	}
+	return left, nil
+}
+
+func (parser *whenParser) operand() (whenExpression, error) {
+	token := parser.next()
+	switch {
+	case token == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case token == "(":
+		expr, err := parser.or()
+		if err != nil {
+			return nil, err
+		}
+		if parser.next() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		return expr, nil
+	case token[0] == '"' || token[0] == '\'':
+		if len(token) < 2 || token[len(token)-1] != token[0] {
+			return nil, fmt.Errorf("unterminated string %s", token)
+		}
+		value := token[1 : len(token)-1]
+		return func(map[string]string, Vars) (string, error) { return value, nil }, nil
+	case token == "true" || token == "false":
+		return func(map[string]string, Vars) (string, error) { return token, nil }, nil
+	}
+	return reference(token)
+}
+
+/*
+Return an expression resolving a reference to a fact or variable
+*/
+func reference(token string) (whenExpression, error) {
+	split := strings.SplitN(token, ".", 2)
+	if len(split) != 2 || split[1] == "" {
+		return nil, fmt.Errorf("unexpected %s, expected a string or a reference such as facts.<name>", token)
+	}
+	name := split[1]
+	switch split[0] {
+	case "facts":
+	case "distro":
+		if name != "family" && name != "init" {
+			return nil, fmt.Errorf("unknown reference %s, expected distro.family or distro.init", token)
+		}
+	case "vars":
+		return func(facts map[string]string, vars Vars) (string, error) {
+			value, exists := vars[name]
+			if !exists {
+				return "", fmt.Errorf("variable %s is not defined", name)
+			}
+			return fmt.Sprint(value), nil
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown reference %s, expected facts.<name>, distro.<name> or vars.<name>", token)
+	}
+	return func(facts map[string]string, vars Vars) (string, error) {
+		return facts[name], nil
+	}, nil
+}
+
+func combine(left, right whenExpression, op func(a, b string) bool) whenExpression {
+	return func(facts map[string]string, vars Vars) (string, error) {
+		a, err := left(facts, vars)
+		if err != nil {
+			return "", err
+		}
+		b, err := right(facts, vars)
+		if err != nil {
+			return "", err
+		}
+		return boolString(op(a, b)), nil
+	}
+}
+
+/*
+A skippedState stands in for a state whose when expression is false on a host
+*/
+type skippedState struct {
+	Metadata Metadata `json:"metadata"`
+	Skipped  bool     `json:"skipped"`
+}
+
+func (skipped *skippedState) Apply() *Result {
+	return skipped.State()
+}
+
+func (skipped *skippedState) State() *Result {
+	return &Result{
+		Consistent: true,
+		Skipped:    true,
+		Metadata:   &skipped.Metadata,
+		Message:    fmt.Sprintf("Skipped, %s is false", skipped.Metadata.When),
+	}
+}
+
+func (skipped *skippedState) Meta() Metadata {
+	return skipped.Metadata
+}
@@ -0,0 +1,84 @@
+package state
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var conditional = []byte(`
+vars:
+  docker: true
+apt-transport-https:
+  package.installed:
+    when: distro.family == "debian"
+yum-utils:
+  package.installed:
+    when: distro.family == 'centos' || facts.role == "builder"
+docker:
+  package.installed:
+    when: vars.docker && !(facts.arch != "amd64")
+`)
+
+func TestWhen(t *testing.T) {
+	vars := Vars{"docker": true, "version": 1.12}
+	for expression, expected := range map[string]bool{
+		`distro.family == "debian"`:                             true,
+		`distro.family != "debian"`:                             false,
+		`facts.role == "worker" || facts.role == "builder"`:     false,
+		`distro.family == "debian" && (vars.version == "1.12")`: true,
+		`!vars.docker`:   false,
+		`facts.missing`:  false,
+		`true && !false`: true,
+	} {
+		applies, err := evaluateWhen(expression, map[string]string{"family": "debian"}, vars)
+		if err != nil || applies != expected {
+			fmt.Println("Bad when result for ", expression, ": ", applies, err)
+			t.Fail()
+		}
+	}
+	for _, expression := range []string{`distro.name == "debian"`, `family == "debian"`, `facts.family ==`, `(facts.family`, `"debian`, `vars.missing`} {
+		if _, err := evaluateWhen(expression, map[string]string{}, Vars{}); err == nil {
+			fmt.Println("Failed to detect invalid when expression ", expression)
+			t.Fail()
+		}
+	}
+}
+
+func TestWhenSkipped(t *testing.T) {
+	stateMap := loadStateMapFromYaml(conditional, t)
+	hostMap, err := stateMap.ForHost("worker-1", map[string]string{"family": "centos", "arch": "amd64"})
+	if err != nil {
+		fmt.Println("Failed to evaluate when expressions: ", err)
+		t.FailNow()
+	}
+	data, err := hostMap.ToJson()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostMap, err = StateMapFromProcessedJson(data)
+	if err != nil {
+		fmt.Println("Failed to load skipped states: ", err)
+		t.FailNow()
+	}
+	skipped := make([]string, 0)
+	for _, state := range hostMap.States {
+		if _, ok := state.(*skippedState); ok {
+			skipped = append(skipped, state.Meta().Name)
+			result := state.State()
+			if !result.Skipped || !result.Consistent {
+				fmt.Println("Skipped state was not reported as skipped: ", result)
+				t.Fail()
+			}
+		}
+	}
+	if strings.Join(skipped, ",") != "apt-transport-https" || len(hostMap.States) != 3 {
+		fmt.Println("Bad skipped states: ", skipped)
+		t.Fail()
+	}
+	_, err = StateMapFromYaml([]byte("docker:\n  package.installed:\n    when: distro.family = debian\n"))
+	if err == nil || !strings.HasPrefix(err.Error(), "line 2: Invalid when expression") {
+		fmt.Println("Failed to detect invalid when expression: ", err)
+		t.Fail()
+	}
+}